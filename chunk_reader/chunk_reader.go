@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"errors"
 	"io"
+	"log_monitor/monitor/core_utils"
 	"math"
 	"sort"
+	"sync/atomic"
 )
 
 const ReadForward = 0
@@ -22,11 +24,23 @@ func getReadOffset(direction int, chunk int64, position int64) int64 {
 	}
 }
 
-func GetProcessBlockReverseFunc(last *parseBlock, parseFunc func(uint64, parseBlock)) func([]byte, int, uint64) {
+// lines longer than maxLength bytes are truncated (see truncateLine); 0 is no limit.
+// buffer isn't kept: the main handed to parseFunc is a pooled copy, which parseFunc owns (see
+// core_utils.GetBuffer), and last keeps a copy of the prefix
+func GetProcessBlockReverseFunc(last *parseBlock, separator byte, maxLength int, parseFunc func(uint64, parseBlock)) func([]byte, int, uint64) {
+	var prefix []byte
 	return func(buffer []byte, amt int, index uint64) {
-		block := getParseBlock(buffer[:amt])
-		block = stitchOtherBlockPrefix(block, *last)
+		block := getParseBlock(buffer[:amt], separator)
+		block.main = truncateLines(block.main, separator, maxLength)
+		if block.main != nil {
+			// with room for the line stitched on, and its truncation marker
+			main := core_utils.GetBuffer(len(block.main) + len(block.suffix) + len(last.prefix) + len(truncatedMarker) + 32)
+			block.main = main[:copy(main, block.main)]
+		}
+		block = stitchOtherBlockPrefixTruncated(block, *last, separator, maxLength)
 		*last = block
+		prefix = append(prefix[:0], block.prefix...)
+		last.prefix = prefix
 		parseFunc(index, block)
 	}
 }
@@ -35,25 +49,40 @@ func AccumulateResults(results <-chan parseResult, expectedMaxIndex <-chan uint6
 	bufferedResults := make([]parseResult, 0)
 	checkMax := false
 	max := uint64(0)
+	var firstErr error
+	// keep receiving after an error so no block is left blocked sending its result
 	for !checkMax || uint64(len(bufferedResults)) < max {
 		select {
 		case res := <-results:
-			if res.err != nil {
-				close(accumulated)
-				defer close(errorReport)
-				errorReport <- res.err
-				return
+			if res.err != nil && firstErr == nil {
+				firstErr = res.err
 			}
 			bufferedResults = append(bufferedResults, res)
 		case max = <-expectedMaxIndex:
 			checkMax = true
 		}
 	}
+	if firstErr != nil {
+		for _, r := range bufferedResults {
+			release(r.result)
+		}
+		close(accumulated)
+		defer close(errorReport)
+		errorReport <- firstErr
+		return
+	}
 
 	sort.Sort(byIndex(bufferedResults))
-	var buffer bytes.Buffer
+	size := 0
+	for _, r := range bufferedResults {
+		if sized, ok := r.result.(interface{ Len() int }); ok {
+			size += sized.Len()
+		}
+	}
+	buffer := bytes.NewBuffer(make([]byte, 0, size))
 	for _, r := range bufferedResults {
 		_, err := buffer.ReadFrom(r.result)
+		release(r.result)
 		if err != nil {
 			close(accumulated)
 			defer close(errorReport)
@@ -66,11 +95,27 @@ func AccumulateResults(results <-chan parseResult, expectedMaxIndex <-chan uint6
 	accumulated <- bytes.NewReader(buffer.Bytes())
 }
 
+// puts a pooled result back; the accumulated results are copied out of them
+func release(result io.ReadSeeker) {
+	if pooled, ok := result.(*core_utils.PooledReader); ok {
+		pooled.Release()
+	}
+}
+
 func ChunkRead(reader io.ReadSeeker, chunk int64, direction int, processChunk func([]byte, int, uint64), keepReading func() bool) (uint64, error) {
+	return ChunkReadPaced(reader, chunk, direction, nil, processChunk, keepReading)
+}
+
+// pace, if not nil, is called with the size of every read before it is made and may block to slow reading down.
+// the buffer handed to processChunk is read into again once it returns, so it mustn't be kept or, since it
+// is the reader's own bytes for a BytesReader, written to
+func ChunkReadPaced(reader io.ReadSeeker, chunk int64, direction int, pace func(int), processChunk func([]byte, int, uint64), keepReading func() bool) (uint64, error) {
 	if chunk <= 0 {
 		return 0, errors.New("cache size must be above zero")
 	}
 
+	inMemory, isBytes := reader.(BytesReader)
+	var buffer []byte
 	somethingProcessed := false
 	index := uint64(0)
 	currentChunk := chunk
@@ -93,18 +138,43 @@ func ChunkRead(reader io.ReadSeeker, chunk int64, direction int, processChunk fu
 			}
 		}
 
-		buffer := make([]byte, int64(math.Abs(float64(offset))))
-		amtRead, err := reader.Read(buffer)
+		if pace != nil {
+			pace(int(currentChunk))
+		}
+		var read []byte
+		if isBytes {
+			read, err = inMemory.Bytes(pos, int(currentChunk))
+			if err == nil {
+				_, err = reader.Seek(int64(len(read)), io.SeekCurrent)
+			}
+		} else {
+			if buffer == nil {
+				buffer = core_utils.GetBuffer(int(chunk))
+				defer core_utils.PutBuffer(buffer)
+			}
+			var amtRead int
+			amtRead, err = reader.Read(buffer[:currentChunk])
+			read = buffer[:amtRead]
+		}
+		atomic.AddInt64(&stats.BytesRead, int64(len(read)))
 		if err != nil {
 			if errors.Is(err, io.EOF) {
 				break
 			} else {
 				return index, err
 			}
-		} else if direction == ReadBackward && int64(amtRead) < -offset {
-			return index, errors.New("truncation detected")
+		} else if direction == ReadBackward && int64(len(read)) < -offset {
+			atomic.AddInt64(&stats.Truncations, 1)
+			return index, errTruncated
 		}
-		processChunk(buffer, amtRead, index)
+		if isBytes {
+			if err := processBytes(processChunk, read, index); err != nil {
+				return index, err
+			}
+		} else {
+			processChunk(read, len(read), index)
+		}
+		atomic.AddInt64(&stats.Chunks, 1)
 		somethingProcessed = true
 
 		if direction == ReadBackward {
@@ -141,9 +211,12 @@ type parseBlock struct {
 	main      []byte
 	suffix    []byte
 	mainCount uint64
+	// bytes cut out of prefix when it is a line too long to keep whole
+	prefixDropped int64
 }
 
-func getParseBlock(buffer []byte) parseBlock {
+// separator is what ends a record; '\n' for lines
+func getParseBlock(buffer []byte, separator byte) parseBlock {
 	var block parseBlock
 	if len(buffer) == 0 {
 		return block
@@ -152,7 +225,7 @@ func getParseBlock(buffer []byte) parseBlock {
 	mainCount := uint64(0)
 	lastNewLineIndex := -1
 	for index, c := range buffer {
-		if c == '\n' {
+		if c == separator {
 			if len(block.prefix) == 0 {
 				block.prefix = buffer[:index+1]
 			} else {
@@ -182,6 +255,13 @@ func getParseBlock(buffer []byte) parseBlock {
 }
 
 func stitchOtherBlockPrefix(one parseBlock, two parseBlock) parseBlock {
+	return stitchOtherBlockPrefixTruncated(one, two, 0, 0)
+}
+
+// the line stitched together is truncated past maxLength; when it is still incomplete (one has no
+// prefix, the line goes on into the block before) only its first maxLength bytes are carried along,
+// so a line spanning any number of blocks takes no more memory than that
+func stitchOtherBlockPrefixTruncated(one parseBlock, two parseBlock, separator byte, maxLength int) parseBlock {
 	var ret parseBlock
 
 	ret.prefix = one.prefix
@@ -192,9 +272,9 @@ func stitchOtherBlockPrefix(one parseBlock, two parseBlock) parseBlock {
 		other = append(other, one.suffix...)
 		other = append(other, two.prefix...)
 		if one.prefix == nil {
-			ret.prefix = append(ret.prefix, other...)
+			ret.prefix, ret.prefixDropped = keepLineHead(other, two.prefixDropped, maxLength)
 		} else {
-			ret.main = append(ret.main, other...)
+			ret.main = append(ret.main, truncateLine(other, two.prefixDropped, separator, maxLength)...)
 			ret.mainCount = one.mainCount + 1
 		}
 	}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"github.com/stretchr/testify/assert"
 	"io"
+	"log_monitor/monitor/core"
 	"log_monitor/monitor/test_utils"
 	"strings"
 	"testing"
@@ -77,6 +78,56 @@ func TestReadReverseNFilter(t *testing.T) {
 	})
 }
 
+// used to crash the process from the accumulating goroutine
+func TestEmptyReader(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		res, err := ReadReverseNLines(strings.NewReader(""), 3, 10)
+		assert.NotNil(t, err)
+		assert.Nil(t, res)
+
+		res, err = ReadReversePassesFilter(strings.NewReader(""), "a", 10)
+		assert.NotNil(t, err)
+		assert.Nil(t, res)
+	}
+}
+
+func TestReadReverseDelimited(t *testing.T) {
+	// every chunk size splits "\r\n" somewhere
+	for _, chunk := range []int64{1, 2, 3, 4, 5, 10000} {
+		t.Run("crlf", func(t *testing.T) {
+			reader := strings.NewReader("abc\r\nde\rf\r\nghi\r\n")
+			reader.Seek(0, io.SeekEnd)
+			res, err := ReadReverseNLinesWith(reader, 3, Options{Chunk: chunk, Delimiter: core.CRLF}, nil)
+			assert.Nil(t, err)
+			assert.Equal(t, "ghi\nde\rf\nabc\n", test_utils.GetString(res))
+
+			reader.Seek(0, io.SeekEnd)
+			res, err = ReadReversePassesFilterWith(reader, "e", Options{Chunk: chunk, Delimiter: core.CRLF}, nil)
+			assert.Nil(t, err)
+			assert.Equal(t, "de\rf\n", test_utils.GetString(res))
+		})
+
+		t.Run("nul", func(t *testing.T) {
+			reader := strings.NewReader("a\nb\x00cd\x00\x00ef\x00")
+			reader.Seek(0, io.SeekEnd)
+			// not mistaken for holes
+			var report Report
+			res, err := ReadReverseNLinesWith(reader, 4, Options{Chunk: chunk, Delimiter: core.NulSeparated, SkipHoles: true}, &report)
+			assert.Nil(t, err)
+			assert.Equal(t, "ef\x00\x00cd\x00a\nb\x00", test_utils.GetString(res))
+			assert.Nil(t, report.Holes)
+		})
+
+		t.Run("custom", func(t *testing.T) {
+			reader := strings.NewReader("a,b\nb,c,")
+			reader.Seek(0, io.SeekEnd)
+			res, err := ReadReversePassesFilterWith(reader, "b", Options{Chunk: chunk, Delimiter: core.Delimiter(",")}, nil)
+			assert.Nil(t, err)
+			assert.Equal(t, "b\nb,", test_utils.GetString(res))
+		})
+	}
+}
+
 func TestAccumulatedResults(t *testing.T) {
 	t.Run("", func(t *testing.T) {
 		results := make(chan parseResult)
@@ -90,7 +141,7 @@ func TestAccumulatedResults(t *testing.T) {
 
 func TestReadReverseAsync(t *testing.T) {
 	c := make(chan parseResult)
-	f := GetReadReverseNLinesAsyncFunc(c)
+	f := GetReadReverseNLinesAsyncFunc(c, core.Records{})
 
 	f(0, []byte("abc\ndef\ngef\n"), 2)
 	res := <-c
@@ -160,7 +211,7 @@ func TestGetProcessReverseBlockFunc(t *testing.T) {
 	}
 
 	var lastPtr parseBlock
-	f := GetProcessBlockReverseFunc(&lastPtr, blockFunc)
+	f := GetProcessBlockReverseFunc(&lastPtr, '\n', 0, blockFunc)
 
 	f([]byte("123\n"), 4, 0)
 	assert.Equal(t, uint64(0), index)
@@ -638,77 +689,77 @@ func TeststitchOtherBlockPrefix(t *testing.T) {
 
 func TestgetParseBlock(t *testing.T) {
 	// empty string
-	block := getParseBlock(bytes.NewBufferString("").Bytes())
+	block := getParseBlock(bytes.NewBufferString("").Bytes(), '\n')
 	assert.Equal(t, block.mainCount, uint64(0))
 	assert.Nil(t, block.prefix)
 	assert.Nil(t, block.main)
 	assert.Nil(t, block.suffix)
 
 	// not a valid line
-	block = getParseBlock(bytes.NewBufferString("123").Bytes())
+	block = getParseBlock(bytes.NewBufferString("123").Bytes(), '\n')
 	assert.Equal(t, block.mainCount, uint64(0))
 	assert.Nil(t, block.prefix)
 	assert.Nil(t, block.main)
 	assert.Equal(t, []byte("123"), block.suffix)
 
 	// blank line
-	block = getParseBlock(bytes.NewBufferString("\n").Bytes())
+	block = getParseBlock(bytes.NewBufferString("\n").Bytes(), '\n')
 	assert.Equal(t, block.mainCount, uint64(0))
 	assert.Equal(t, []byte("\n"), block.prefix)
 	assert.Nil(t, block.main)
 	assert.Nil(t, block.suffix)
 
 	// blank lines
-	block = getParseBlock(bytes.NewBufferString("\n\n\n").Bytes())
+	block = getParseBlock(bytes.NewBufferString("\n\n\n").Bytes(), '\n')
 	assert.Equal(t, block.mainCount, uint64(2))
 	assert.Equal(t, []byte("\n"), block.prefix)
 	assert.Equal(t, []byte("\n\n"), block.main)
 	assert.Nil(t, block.suffix)
 
 	// blank lines with remainder
-	block = getParseBlock(bytes.NewBufferString("\n\n\n123").Bytes())
+	block = getParseBlock(bytes.NewBufferString("\n\n\n123").Bytes(), '\n')
 	assert.Equal(t, block.mainCount, uint64(2))
 	assert.Equal(t, []byte("\n"), block.prefix)
 	assert.Equal(t, []byte("\n\n"), block.main)
 	assert.Equal(t, []byte("123"), block.suffix)
 
 	// one line
-	block = getParseBlock(bytes.NewBufferString("123\n").Bytes())
+	block = getParseBlock(bytes.NewBufferString("123\n").Bytes(), '\n')
 	assert.Equal(t, block.mainCount, uint64(0))
 	assert.Equal(t, []byte("123\n"), block.prefix)
 	assert.Nil(t, block.main)
 	assert.Nil(t, block.suffix)
 
 	// two lines
-	block = getParseBlock(bytes.NewBufferString("123\n456\n").Bytes())
+	block = getParseBlock(bytes.NewBufferString("123\n456\n").Bytes(), '\n')
 	assert.Equal(t, block.mainCount, uint64(1))
 	assert.Equal(t, []byte("123\n"), block.prefix)
 	assert.Equal(t, []byte("456\n"), block.main)
 	assert.Nil(t, block.suffix)
 
 	// three lines
-	block = getParseBlock(bytes.NewBufferString("123\n456\n789\n").Bytes())
+	block = getParseBlock(bytes.NewBufferString("123\n456\n789\n").Bytes(), '\n')
 	assert.Equal(t, block.mainCount, uint64(2))
 	assert.Equal(t, []byte("123\n"), block.prefix)
 	assert.Equal(t, []byte("456\n789\n"), block.main)
 	assert.Nil(t, block.suffix)
 
 	// four lines
-	block = getParseBlock(bytes.NewBufferString("123\n456\n789\n012\n").Bytes())
+	block = getParseBlock(bytes.NewBufferString("123\n456\n789\n012\n").Bytes(), '\n')
 	assert.Equal(t, block.mainCount, uint64(3))
 	assert.Equal(t, []byte("123\n"), block.prefix)
 	assert.Equal(t, []byte("456\n789\n012\n"), block.main)
 	assert.Nil(t, block.suffix)
 
 	// four lines, partial 5th
-	block = getParseBlock(bytes.NewBufferString("123\n456\n789\n012\nabc").Bytes())
+	block = getParseBlock(bytes.NewBufferString("123\n456\n789\n012\nabc").Bytes(), '\n')
 	assert.Equal(t, block.mainCount, uint64(3))
 	assert.Equal(t, []byte("123\n"), block.prefix)
 	assert.Equal(t, []byte("456\n789\n012\n"), block.main)
 	assert.Equal(t, []byte("abc"), block.suffix)
 
 	// partial 2nd (one line)
-	block = getParseBlock(bytes.NewBufferString("123\n4").Bytes())
+	block = getParseBlock(bytes.NewBufferString("123\n4").Bytes(), '\n')
 	assert.Equal(t, block.mainCount, uint64(0))
 	assert.Equal(t, []byte("123\n"), block.prefix)
 	assert.Nil(t, block.main)
@@ -5,9 +5,15 @@ import (
 	"errors"
 	"io"
 	"log_monitor/monitor/core"
+	"log_monitor/monitor/core_utils"
 )
 
 func ReadReversePassesFilter(reader io.ReadSeeker, expr string, chunk int64) (io.ReadSeeker, error) {
+	return ReadReversePassesFilterWith(reader, expr, Options{Chunk: chunk}, nil)
+}
+
+// report may be nil
+func ReadReversePassesFilterWith(reader io.ReadSeeker, expr string, options Options, report *Report) (io.ReadSeeker, error) {
 	validBlockCount := uint64(0)
 
 	results := make(chan parseResult)
@@ -20,54 +26,69 @@ func ReadReversePassesFilter(reader io.ReadSeeker, expr string, chunk int64) (io
 	defer close(expected)
 
 	var lastBlock parseBlock
-	filter := GetReadReverseAsyncFuncFilter(results, expr)
-	processBlock := GetProcessBlockReverseFunc(&lastBlock, func(index uint64, block parseBlock) {
+	var carry []byte
+	filter := GetReadReverseAsyncFuncFilter(results, expr, options.records())
+	processRecords := func(index uint64, block parseBlock) {
 		if block.main != nil {
 			filter(validBlockCount, block.main, block.mainCount)
 			validBlockCount++
 		}
-	})
+	}
+	processBlock := GetProcessBlockReverseFunc(&lastBlock, options.Delimiter.Separator(), options.MaxLength, options.recordParser(&carry, processRecords))
 	keepReading := func() bool {
-		// early kill here?
-		return true
+		return !options.canceled()
 	}
 
-	i, err := ChunkRead(reader, chunk, ReadBackward, processBlock, keepReading)
-	if err != nil {
-		return nil, err
+	var i uint64
+	processChunk, err := options.reverseChunkProcessor(reader, report, processBlock)
+	if err == nil {
+		i, err = ChunkReadPaced(reader, options.Chunk, ReadBackward, getPaceFunc(options.Throttles, report, options.sleep), processChunk, keepReading)
+	}
+	if err == nil && options.canceled() {
+		err = ErrCanceled
 	}
 
-	{
-		dummy := parseBlock{prefix: []byte("dummy\n")}
-		dummy = stitchOtherBlockPrefix(dummy, lastBlock)
+	if err == nil {
+		dummy := options.startOfFileBlock()
+		dummy = stitchOtherBlockPrefixTruncated(dummy, lastBlock, options.Delimiter.Separator(), options.MaxLength)
 		if dummy.main != nil {
 			processBlock(dummy.main, len(dummy.main), i+1)
 			i++
 		} else {
-			return nil, errors.New("parse error")
+			err = errors.New("parse error")
 		}
 	}
+	// continuation lines at the start of the file, without a record start of their own
+	if err == nil && len(carry) > 0 {
+		processRecords(i+1, parseBlock{main: carry, mainCount: 1})
+	}
 
+	// the accumulator has to be told how many blocks to expect even on error,
+	// otherwise it is left waiting and blocks in flight panic sending on a closed channel
 	expected <- validBlockCount
-
-	err = <-errChannel
+	accumulateErr := <-errChannel
+	res := <-accumulated
 	if err != nil {
 		return nil, err
+	} else if accumulateErr != nil {
+		return nil, accumulateErr
 	}
-	return <-accumulated, nil
+	return res, nil
 }
 
-func GetReadReverseAsyncFuncFilter(parseResultChan chan<- parseResult, expr string) func(uint64, []byte, uint64) {
+// buffer is handed over, and put back in the pool once parsed
+func GetReadReverseAsyncFuncFilter(parseResultChan chan<- parseResult, expr string, records core.Records) func(uint64, []byte, uint64) {
 	return func(index uint64, buffer []byte, nLines uint64) {
-		go func() {
+		queueParse(func() {
 			reader := bytes.NewReader(buffer)
 			reader.Seek(0, io.SeekEnd)
-			res, err := core.ReadReversePassesFilterFast(reader, expr)
+			res, err := core.ReadReverseRecordsPassFilterFast(reader, expr, records)
+			core_utils.PutBuffer(buffer)
 			parseResultChan <- parseResult{
 				index:  index,
 				result: res,
 				err:    err,
 			}
-		}()
+		})
 	}
 }
@@ -0,0 +1,153 @@
+package chunk_reader
+
+import (
+	"bytes"
+	"io"
+	"log_monitor/monitor/core_utils"
+	"sync"
+)
+
+// like ReadReversePassesFilterWith over the first end bytes of reader, which are split into up to ranges
+// ranges read at the same time. Range boundaries start out at multiples of options.Chunk and are moved up
+// to just past the next separator, so every range holds whole records and the lines either side of a
+// boundary need no stitching; a range that gets swallowed that way (a line longer than the range) is dropped.
+// Multi-line records are read as one range, a line doesn't tell whether it starts a record without the ones
+// before it. A reader with a Section(offset, length) method gives the ranges itself. report may be nil
+func ReadReversePassesFilterAt(reader io.ReaderAt, end int64, expr string, ranges int, options Options, report *Report) (io.ReadSeeker, error) {
+	if options.RecordStart != nil {
+		ranges = 1
+	}
+	starts, err := rangeStarts(reader, end, ranges, options.Chunk, options.Delimiter.Separator())
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]io.ReadSeeker, len(starts))
+	errs := make([]error, len(starts))
+	reports := make([]Report, len(starts))
+	var wg sync.WaitGroup
+	for i, start := range starts {
+		rangeEnd := end
+		rangeOptions := options
+		if i < len(starts)-1 {
+			rangeEnd = starts[i+1]
+			// ends on a separator, only the end of the file can be in the middle of a record
+			rangeOptions.Partial = ExcludePartial
+		}
+		var section io.ReadSeeker = io.NewSectionReader(reader, start, rangeEnd-start)
+		if sections, ok := reader.(interface {
+			Section(offset int64, length int64) io.ReadSeeker
+		}); ok {
+			// a reader that knows how, hands out a section of its own; a BytesReader stays one
+			section = sections.Section(start, rangeEnd-start)
+		}
+		wg.Add(1)
+		go func(i int, options Options) {
+			defer wg.Done()
+			if _, errs[i] = section.Seek(0, io.SeekEnd); errs[i] == nil {
+				results[i], errs[i] = ReadReversePassesFilterWith(section, expr, options, &reports[i])
+			}
+		}(i, rangeOptions)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	size := int64(0)
+	for i := len(starts) - 1; i >= 0; i-- {
+		if sized, ok := results[i].(interface{ Len() int }); ok {
+			size += int64(sized.Len())
+		}
+		mergeReport(report, reports[i], starts[i])
+	}
+	// newest range first
+	buffer := bytes.NewBuffer(make([]byte, 0, size))
+	for i := len(starts) - 1; i >= 0; i-- {
+		if _, err := buffer.ReadFrom(results[i]); err != nil {
+			return nil, err
+		}
+	}
+	return bytes.NewReader(buffer.Bytes()), nil
+}
+
+// where each range starts; the first at 0
+func rangeStarts(reader io.ReaderAt, end int64, ranges int, chunk int64, separator byte) ([]int64, error) {
+	if ranges < 1 {
+		ranges = 1
+	}
+	size := (end + int64(ranges) - 1) / int64(ranges)
+	if chunk > 0 {
+		size = (size + chunk - 1) / chunk * chunk
+	}
+	starts := []int64{0}
+	if size == 0 {
+		return starts, nil
+	}
+	for boundary := size; boundary < end; boundary += size {
+		if last := starts[len(starts)-1]; boundary <= last {
+			continue
+		}
+		start, found, err := recordEndFrom(reader, boundary-1, end, chunk, separator)
+		if err != nil {
+			return nil, err
+		}
+		if found && start < end {
+			starts = append(starts, start)
+		}
+	}
+	// the last range could be a fragment without a separator, which is only read along with the record before it
+	if last := len(starts) - 1; last > 0 {
+		if _, found, err := recordEndFrom(reader, starts[last], end, chunk, separator); err != nil {
+			return nil, err
+		} else if !found {
+			starts = starts[:last]
+		}
+	}
+	return starts, nil
+}
+
+// the position just past the first separator at or after from, if there is one before end
+func recordEndFrom(reader io.ReaderAt, from int64, end int64, chunk int64, separator byte) (int64, bool, error) {
+	window := int64(4096)
+	if chunk > 0 && chunk < window {
+		window = chunk
+	}
+	buffer := core_utils.GetBuffer(int(window))
+	defer core_utils.PutBuffer(buffer)
+	for from < end {
+		if end-from < window {
+			buffer = buffer[:end-from]
+		}
+		amt, err := reader.ReadAt(buffer, from)
+		if i := bytes.IndexByte(buffer[:amt], separator); i != -1 {
+			return from + int64(i) + 1, true, nil
+		}
+		if err != nil {
+			return 0, false, err
+		}
+		from += int64(amt)
+	}
+	return end, false, nil
+}
+
+// adds what was found reading the range starting at start; ranges are merged newest first
+func mergeReport(report *Report, rangeReport Report, start int64) {
+	if report == nil {
+		return
+	}
+	holes := make([]Hole, 0, len(rangeReport.Holes))
+	for _, hole := range rangeReport.Holes {
+		holes = append(holes, Hole{Offset: start + hole.Offset, Length: hole.Length})
+	}
+	report.addHolesBefore(holes)
+	report.Partial += rangeReport.Partial
+	report.ThrottleRate = rangeReport.ThrottleRate
+	// the ranges wait at the same time
+	if rangeReport.ThrottleWait > report.ThrottleWait {
+		report.ThrottleWait = rangeReport.ThrottleWait
+	}
+	report.BytesRead += rangeReport.BytesRead
+}
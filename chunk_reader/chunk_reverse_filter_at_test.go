@@ -0,0 +1,88 @@
+package chunk_reader
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"log_monitor/monitor/test_utils"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// lines of random length, some of them long, holes, and a fragment at the end
+func randomLog(random *rand.Rand) string {
+	var builder strings.Builder
+	for i := 0; i < 200; i++ {
+		switch random.Intn(20) {
+		case 0:
+			builder.WriteString(strings.Repeat("\x00", random.Intn(50)+1))
+		case 1:
+			builder.WriteString(strings.Repeat("long", random.Intn(100)) + "\n")
+		default:
+			builder.WriteString(strings.Repeat("ab", random.Intn(10)) + "\n")
+		}
+	}
+	builder.WriteString("fragment")
+	return builder.String()
+}
+
+func TestReadReversePassesFilterAt_SameAsSequential(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 20; i++ {
+		log := randomLog(random)
+		for _, chunk := range []int64{1, 7, 64, 1000, 64000} {
+			for _, options := range []Options{
+				{Chunk: chunk},
+				{Chunk: chunk, SkipHoles: true, Partial: IncludePartial},
+				{Chunk: chunk, SkipHoles: true, MaxLength: 30},
+			} {
+				reader := strings.NewReader(log)
+				reader.Seek(0, io.SeekEnd)
+				var expectedReport Report
+				expected, err := ReadReversePassesFilterWith(reader, "ab", options, &expectedReport)
+				assert.Nil(t, err)
+				expectedLines := test_utils.GetLines(expected)
+
+				for _, ranges := range []int{1, 2, 3, 8, 100} {
+					var report Report
+					res, err := ReadReversePassesFilterAt(strings.NewReader(log), int64(len(log)), "ab", ranges, options, &report)
+					assert.Nil(t, err)
+					assert.Equal(t, expectedLines, test_utils.GetLines(res))
+					assert.Equal(t, expectedReport.Holes, report.Holes)
+					assert.Equal(t, expectedReport.Partial, report.Partial)
+					assert.Equal(t, int64(len(log)), report.BytesRead)
+				}
+			}
+		}
+	}
+}
+
+func TestReadReversePassesFilterAt_End(t *testing.T) {
+	log := "abc\ndef\nabd\nghi\n"
+	// what is after end is left out
+	res, err := ReadReversePassesFilterAt(strings.NewReader(log), 12, "ab", 2, Options{Chunk: 4}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"abd\n", "abc\n"}, test_utils.GetLines(res))
+
+	// nothing to read fails, like it does reading sequentially
+	_, err = ReadReversePassesFilterAt(strings.NewReader(log), 0, "ab", 2, Options{Chunk: 4}, nil)
+	assert.NotNil(t, err)
+}
+
+func TestRangeStarts(t *testing.T) {
+	reader := strings.NewReader("aaaaaaa\nbbb\nc\nddddddddddddddddddd\ne\n")
+	starts, err := rangeStarts(reader, reader.Size(), 4, 4, '\n')
+	assert.Nil(t, err)
+	// ranges of 12 (9 rounded up to a chunk); 12 is on a line start, 24 moves up to the line after the long one
+	assert.Equal(t, []int64{0, 12, 34}, starts)
+
+	// a fragment is read with the line before it
+	reader = strings.NewReader("aaaaaaa\nbbbbbbbbbbbbbbbbbbb")
+	starts, err = rangeStarts(reader, reader.Size(), 4, 4, '\n')
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{0}, starts)
+
+	starts, err = rangeStarts(reader, reader.Size(), 1, 4, '\n')
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{0}, starts)
+}
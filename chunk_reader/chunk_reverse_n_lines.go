@@ -5,9 +5,15 @@ import (
 	"errors"
 	"io"
 	"log_monitor/monitor/core"
+	"log_monitor/monitor/core_utils"
 )
 
 func ReadReverseNLines(reader io.ReadSeeker, nLines uint64, chunk int64) (io.ReadSeeker, error) {
+	return ReadReverseNLinesWith(reader, nLines, Options{Chunk: chunk}, nil)
+}
+
+// report may be nil
+func ReadReverseNLinesWith(reader io.ReadSeeker, nLines uint64, options Options, report *Report) (io.ReadSeeker, error) {
 	count := uint64(0)
 	validBlockCount := uint64(0)
 
@@ -21,35 +27,48 @@ func ReadReverseNLines(reader io.ReadSeeker, nLines uint64, chunk int64) (io.Rea
 	defer close(expected)
 
 	var lastBlock parseBlock
-	processBlock := GetProcessBlockReverseFunc(&lastBlock, GetProcessBlockReverseNLinesLimitFunc(&validBlockCount, &count, nLines, GetReadReverseNLinesAsyncFunc(results)))
+	var carry []byte
+	processRecords := GetProcessBlockReverseNLinesLimitFunc(&validBlockCount, &count, nLines, GetReadReverseNLinesAsyncFunc(results, options.records()))
+	processBlock := GetProcessBlockReverseFunc(&lastBlock, options.Delimiter.Separator(), options.MaxLength, options.recordParser(&carry, processRecords))
 	keepReading := func() bool {
-		// early kill here?
-		return count < nLines
+		return count < nLines && !options.canceled()
 	}
 
-	i, err := ChunkRead(reader, chunk, ReadBackward, processBlock, keepReading)
-	if err != nil {
-		return nil, err
+	var i uint64
+	processChunk, err := options.reverseChunkProcessor(reader, report, processBlock)
+	if err == nil {
+		i, err = ChunkReadPaced(reader, options.Chunk, ReadBackward, getPaceFunc(options.Throttles, report, options.sleep), processChunk, keepReading)
+	}
+	if err == nil && options.canceled() {
+		err = ErrCanceled
 	}
 
-	if count < nLines {
-		dummy := parseBlock{prefix: []byte("dummy\n")}
-		dummy = stitchOtherBlockPrefix(dummy, lastBlock)
+	if err == nil && count < nLines {
+		dummy := options.startOfFileBlock()
+		dummy = stitchOtherBlockPrefixTruncated(dummy, lastBlock, options.Delimiter.Separator(), options.MaxLength)
 		if dummy.main != nil {
 			processBlock(dummy.main, len(dummy.main), i+1)
 			i++
 		} else {
-			return nil, errors.New("parse error")
+			err = errors.New("parse error")
 		}
 	}
+	// continuation lines at the start of the file, without a record start of their own
+	if err == nil && count < nLines && len(carry) > 0 {
+		processRecords(i+1, parseBlock{main: carry, mainCount: 1})
+	}
 
+	// the accumulator has to be told how many blocks to expect even on error,
+	// otherwise it is left waiting and blocks in flight panic sending on a closed channel
 	expected <- validBlockCount
-
-	err = <-errChannel
+	accumulateErr := <-errChannel
+	res := <-accumulated
 	if err != nil {
 		return nil, err
+	} else if accumulateErr != nil {
+		return nil, accumulateErr
 	}
-	return <-accumulated, nil
+	return res, nil
 }
 
 func GetProcessBlockReverseNLinesLimitFunc(index *uint64, currentCount *uint64, lineLimit uint64, processFunc func(uint64, []byte, uint64)) func(uint64, parseBlock) {
@@ -66,17 +85,19 @@ func GetProcessBlockReverseNLinesLimitFunc(index *uint64, currentCount *uint64,
 	}
 }
 
-func GetReadReverseNLinesAsyncFunc(parseResultChan chan<- parseResult) func(uint64, []byte, uint64) {
+// buffer is handed over, and put back in the pool once parsed
+func GetReadReverseNLinesAsyncFunc(parseResultChan chan<- parseResult, records core.Records) func(uint64, []byte, uint64) {
 	return func(index uint64, buffer []byte, nLines uint64) {
-		go func() {
+		queueParse(func() {
 			reader := bytes.NewReader(buffer)
 			reader.Seek(0, io.SeekEnd)
-			res, err := core.ReadReverseNLinesFast(reader, nLines)
+			res, err := core.ReadReverseNRecordsFast(reader, nLines, records)
+			core_utils.PutBuffer(buffer)
 			parseResultChan <- parseResult{
 				index:  index,
 				result: res,
 				err:    err,
 			}
-		}()
+		})
 	}
 }
@@ -0,0 +1,40 @@
+package chunk_reader
+
+import "bytes"
+
+// copytruncate rotation leaves a run of NUL bytes at the start of the file when the writer keeps
+// its old offset; the bytes are compacted out of each chunk before it is parsed, so the lines on either
+// side come through and the run isn't treated as part of one giant line.
+// end is the position reading backwards started from. chunks with holes are compacted into a buffer of
+// its own, the one read into may be read only (see BytesReader)
+func GetSkipHolesReverseFunc(end int64, chunk int64, report *Report, processChunk func([]byte, int, uint64)) func([]byte, int, uint64) {
+	var compactedBuffer []byte
+	return func(buffer []byte, amt int, index uint64) {
+		if bytes.IndexByte(buffer[:amt], 0) == -1 {
+			processChunk(buffer, amt, index)
+			return
+		}
+		offset := end - int64(index)*chunk - int64(amt)
+		compactedBuffer = append(compactedBuffer[:0], buffer[:amt]...)
+		buffer = compactedBuffer
+
+		var holes []Hole
+		compacted := 0
+		for i := 0; i < amt; {
+			if buffer[i] != 0 {
+				buffer[compacted] = buffer[i]
+				compacted++
+				i++
+				continue
+			}
+
+			start := i
+			for i < amt && buffer[i] == 0 {
+				i++
+			}
+			holes = append(holes, Hole{Offset: offset + int64(start), Length: int64(i - start)})
+		}
+		report.addHolesBefore(holes)
+		processChunk(buffer, compacted, index)
+	}
+}
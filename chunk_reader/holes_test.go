@@ -0,0 +1,80 @@
+package chunk_reader
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"log_monitor/monitor/test_utils"
+	"strings"
+	"testing"
+)
+
+func TestSkipHoles(t *testing.T) {
+	for _, chunk := range []int64{1, 2, 3, 5, 64000} {
+		t.Run("hole at the start", func(t *testing.T) {
+			reader := strings.NewReader("\x00\x00\x00\x00abc\ndef\n")
+			reader.Seek(0, io.SeekEnd)
+
+			var report Report
+			res, err := ReadReverseNLinesWith(reader, 10, Options{Chunk: chunk, SkipHoles: true}, &report)
+			assert.Nil(t, err)
+			assert.Equal(t, []string{"def\n", "abc\n"}, test_utils.GetLines(res))
+			assert.Equal(t, []Hole{{Offset: 0, Length: 4}}, report.Holes)
+		})
+
+		t.Run("holes between lines", func(t *testing.T) {
+			reader := strings.NewReader("abc\n\x00\x00\x00def\n\x00ghi\n")
+			reader.Seek(0, io.SeekEnd)
+
+			var report Report
+			res, err := ReadReversePassesFilterWith(reader, "", Options{Chunk: chunk, SkipHoles: true}, &report)
+			assert.Nil(t, err)
+			assert.Equal(t, []string{"ghi\n", "def\n", "abc\n"}, test_utils.GetLines(res))
+			assert.Equal(t, []Hole{{Offset: 4, Length: 3}, {Offset: 11, Length: 1}}, report.Holes)
+		})
+
+		// same as an empty file
+		t.Run("nothing but a hole", func(t *testing.T) {
+			reader := strings.NewReader("\x00\x00\x00\x00\x00")
+			reader.Seek(0, io.SeekEnd)
+
+			var report Report
+			res, err := ReadReverseNLinesWith(reader, 10, Options{Chunk: chunk, SkipHoles: true}, &report)
+			assert.NotNil(t, err)
+			assert.Nil(t, res)
+			assert.Equal(t, []Hole{{Offset: 0, Length: 5}}, report.Holes)
+		})
+	}
+
+	t.Run("not skipped unless asked", func(t *testing.T) {
+		reader := strings.NewReader("\x00\x00abc\ndef\n")
+		reader.Seek(0, io.SeekEnd)
+
+		var report Report
+		res, err := ReadReverseNLinesWith(reader, 10, Options{Chunk: 3}, &report)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"def\n", "\x00\x00abc\n"}, test_utils.GetLines(res))
+		assert.Nil(t, report.Holes)
+	})
+
+	t.Run("nil report", func(t *testing.T) {
+		reader := strings.NewReader("\x00\x00abc\ndef\n")
+		reader.Seek(0, io.SeekEnd)
+
+		res, err := ReadReverseNLinesWith(reader, 10, Options{Chunk: 3, SkipHoles: true}, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"def\n", "abc\n"}, test_utils.GetLines(res))
+	})
+}
+
+func TestAddHolesBefore(t *testing.T) {
+	var report Report
+	report.addHolesBefore(nil)
+	assert.Nil(t, report.Holes)
+
+	report.addHolesBefore([]Hole{{Offset: 10, Length: 2}})
+	report.addHolesBefore([]Hole{{Offset: 2, Length: 2}, {Offset: 6, Length: 4}})
+	assert.Equal(t, []Hole{{Offset: 2, Length: 2}, {Offset: 6, Length: 6}}, report.Holes)
+
+	var nilReport *Report
+	nilReport.addHolesBefore([]Hole{{Offset: 0, Length: 1}})
+}
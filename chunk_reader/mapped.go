@@ -0,0 +1,34 @@
+package chunk_reader
+
+import (
+	"errors"
+	"io"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// a reader over bytes already in memory, such as a mapped file: ChunkRead hands its bytes straight to
+// processChunk instead of reading them into a buffer. They are read only, and reading them may fault once
+// the file underneath shrinks, which fails the read as a truncation
+type BytesReader interface {
+	io.ReadSeeker
+	// up to length bytes at offset, fewer at the end and io.EOF past it
+	Bytes(offset int64, length int) ([]byte, error)
+}
+
+var errTruncated = errors.New("truncation detected")
+
+func processBytes(processChunk func([]byte, int, uint64), chunk []byte, index uint64) (err error) {
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if r := recover(); r != nil {
+			if _, fault := r.(interface{ Addr() uintptr }); !fault {
+				panic(r)
+			}
+			atomic.AddInt64(&stats.Truncations, 1)
+			err = errTruncated
+		}
+	}()
+	processChunk(chunk, len(chunk), index)
+	return nil
+}
@@ -0,0 +1,115 @@
+package chunk_reader
+
+import (
+	"errors"
+	"io"
+	"log_monitor/monitor/core"
+	"regexp"
+	"time"
+)
+
+// settings for a single read; the zero value besides Chunk reads the file as is
+type Options struct {
+	Chunk     int64
+	SkipHoles bool // drop runs of NUL bytes (see GetSkipHolesReverseFunc); not when records are NUL separated
+	Delimiter core.Delimiter
+	// multi-line records start with a line matching this (see core.Records); lines and filters
+	// then count and match whole records
+	RecordStart *regexp.Regexp
+	Partial     Partial
+	PartialWait time.Duration // for WaitPartial; waiting is up to the caller, which knows the reader is a growing file
+	// lines longer than this many bytes are cut short with a marker giving their length, which also bounds
+	// the memory a single line takes; 0 is no limit
+	MaxLength int
+	// reading is paced to the slowest of these; typically one for the read and one shared by all reads
+	Throttles []*Throttle
+	// once closed, reading stops and fails with ErrCanceled; typically a request context's Done()
+	Cancel <-chan struct{}
+}
+
+var ErrCanceled = errors.New("read canceled")
+
+func (o Options) canceled() bool {
+	select {
+	case <-o.Cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+// waits out throttling, cut short by Cancel
+func (o Options) sleep(d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-o.Cancel:
+	}
+}
+
+func (o Options) records() core.Records {
+	return core.Records{Delimiter: o.Delimiter, Start: o.RecordStart}
+}
+
+// wraps parseFunc so it is handed whole records; carry is what is left over at the start of the file
+func (o Options) recordParser(carry *[]byte, parseFunc func(uint64, parseBlock)) func(uint64, parseBlock) {
+	if o.RecordStart == nil {
+		return parseFunc
+	}
+	return GetGroupRecordsReverseFunc(o.records(), o.MaxLength, carry, parseFunc)
+}
+
+// what was found while reading, besides the lines themselves
+type Report struct {
+	Holes   []Hole // in file order
+	Partial int64  // length of the unterminated fragment read as the newest record, if any
+
+	ThrottleRate int64         // bytes a second reading was paced to, 0 if it wasn't
+	ThrottleWait time.Duration // spent waiting on it
+
+	BytesRead int64 // from the reader, going by the chunks asked for
+}
+
+// a run of NUL bytes in the file, skipped over
+type Hole struct {
+	Offset int64
+	Length int64
+}
+
+// wraps processChunk according to the options; must be called at the position reading starts from
+func (o Options) reverseChunkProcessor(reader io.Seeker, report *Report, processChunk func([]byte, int, uint64)) (func([]byte, int, uint64), error) {
+	if o.Partial != ExcludePartial {
+		// holes are skipped first, a fragment can be all NULs
+		processChunk = GetIncludePartialReverseFunc(o.Delimiter.Separator(), report, processChunk)
+	}
+	if !o.SkipHoles || o.Delimiter.Separator() == 0 {
+		return processChunk, nil
+	}
+	end, err := reader.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	return GetSkipHolesReverseFunc(end, o.Chunk, report, processChunk), nil
+}
+
+// stands in for the block before the start of the file, so the first record gets stitched into a main
+func (o Options) startOfFileBlock() parseBlock {
+	return parseBlock{prefix: []byte{'x', o.Delimiter.Separator()}}
+}
+
+// holes are found back to front; every call is for holes before the ones already known
+func (r *Report) addHolesBefore(holes []Hole) {
+	if r == nil || len(holes) == 0 {
+		return
+	}
+	if len(r.Holes) > 0 {
+		last := &holes[len(holes)-1]
+		if last.Offset+last.Length == r.Holes[0].Offset {
+			// one hole that straddles chunks
+			last.Length += r.Holes[0].Length
+			r.Holes = r.Holes[1:]
+		}
+	}
+	r.Holes = append(holes, r.Holes...)
+}
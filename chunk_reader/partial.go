@@ -0,0 +1,56 @@
+package chunk_reader
+
+import (
+	"bytes"
+	"errors"
+	"time"
+)
+
+// what to do with the bytes after the last separator; a writer may be in the middle of the record
+type Partial int
+
+const (
+	ExcludePartial Partial = iota // the fragment is left out
+	IncludePartial                // the fragment is read as the newest record
+	WaitPartial                   // like IncludePartial, after waiting up to Options.PartialWait for the writer to end it
+)
+
+func ParsePartial(partial string) (Partial, error) {
+	switch partial {
+	case "", "exclude":
+		return ExcludePartial, nil
+	case "include":
+		return IncludePartial, nil
+	case "wait":
+		return WaitPartial, nil
+	}
+	return ExcludePartial, errors.New("unknown partial: " + partial)
+}
+
+// how long WaitPartial waits when Options.PartialWait isn't set
+const DefaultPartialWait = time.Second
+
+// ends the fragment at the end of the first chunk read backwards (the end of the file) with separator,
+// so it is parsed like any other record; its length, which may span chunks, is reported
+func GetIncludePartialReverseFunc(separator byte, report *Report, processChunk func([]byte, int, uint64)) func([]byte, int, uint64) {
+	inFragment := false
+	return func(buffer []byte, amt int, index uint64) {
+		unterminated := index == 0 && amt > 0 && buffer[amt-1] != separator
+		if unterminated {
+			inFragment = true
+		}
+		if inFragment {
+			fragment := bytes.LastIndexByte(buffer[:amt], separator)
+			inFragment = fragment == -1
+			if report != nil {
+				report.Partial += int64(amt - 1 - fragment)
+			}
+		}
+
+		if unterminated {
+			processChunk(append(buffer[:amt:amt], separator), amt+1, index)
+			return
+		}
+		processChunk(buffer, amt, index)
+	}
+}
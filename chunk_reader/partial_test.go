@@ -0,0 +1,75 @@
+package chunk_reader
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"log_monitor/monitor/core"
+	"log_monitor/monitor/test_utils"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestParsePartial(t *testing.T) {
+	for name, expected := range map[string]Partial{"": ExcludePartial, "exclude": ExcludePartial, "include": IncludePartial, "wait": WaitPartial} {
+		partial, err := ParsePartial(name)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, partial)
+	}
+	_, err := ParsePartial("all")
+	assert.NotNil(t, err)
+}
+
+func TestIncludePartial(t *testing.T) {
+	for _, chunk := range []int64{1, 2, 3, 5, 10000} {
+		reader := strings.NewReader("abc\ndef\ngh")
+
+		reader.Seek(0, io.SeekEnd)
+		var report Report
+		res, err := ReadReverseNLinesWith(reader, 2, Options{Chunk: chunk}, &report)
+		assert.Nil(t, err)
+		assert.Equal(t, "def\nabc\n", test_utils.GetString(res))
+		assert.Equal(t, int64(0), report.Partial)
+
+		reader.Seek(0, io.SeekEnd)
+		res, err = ReadReverseNLinesWith(reader, 2, Options{Chunk: chunk, Partial: IncludePartial}, &report)
+		assert.Nil(t, err)
+		assert.Equal(t, "gh\ndef\n", test_utils.GetString(res))
+		assert.Equal(t, int64(2), report.Partial)
+
+		reader.Seek(0, io.SeekEnd)
+		res, err = ReadReversePassesFilterWith(reader, "g", Options{Chunk: chunk, Partial: WaitPartial}, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, "gh\n", test_utils.GetString(res))
+
+		// a file that is all fragment
+		reader = strings.NewReader("abc")
+		reader.Seek(0, io.SeekEnd)
+		report = Report{}
+		res, err = ReadReverseNLinesWith(reader, 1, Options{Chunk: chunk, Partial: IncludePartial}, &report)
+		assert.Nil(t, err)
+		assert.Equal(t, "abc\n", test_utils.GetString(res))
+		assert.Equal(t, int64(3), report.Partial)
+
+		// nothing to include
+		reader = strings.NewReader("abc\n")
+		reader.Seek(0, io.SeekEnd)
+		report = Report{}
+		res, err = ReadReverseNLinesWith(reader, 1, Options{Chunk: chunk, Partial: IncludePartial}, &report)
+		assert.Nil(t, err)
+		assert.Equal(t, "abc\n", test_utils.GetString(res))
+		assert.Equal(t, int64(0), report.Partial)
+	}
+}
+
+func TestIncludePartial_Records(t *testing.T) {
+	// an unterminated continuation line belongs to the record before it
+	for _, chunk := range []int64{1, 4, 10000} {
+		reader := strings.NewReader("1 a\n1 b\n\tat c\n\tat d")
+		reader.Seek(0, io.SeekEnd)
+		options := Options{Chunk: chunk, Partial: IncludePartial, RecordStart: regexp.MustCompile(`^\d `), Delimiter: core.NewLine}
+		res, err := ReadReverseNLinesWith(reader, 1, options, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, "1 b\n\tat c\n\tat d\n", test_utils.GetString(res))
+	}
+}
@@ -0,0 +1,93 @@
+package chunk_reader
+
+import (
+	"bytes"
+	"log_monitor/monitor/core"
+	"log_monitor/monitor/core_utils"
+	"strconv"
+)
+
+// the continuation lines carried over to the next block are cut down to maxLength bytes, or to this
+// many when the read has no line length limit, so a record start that never matches doesn't keep the
+// whole file in memory
+const maxCarriedLength = 16 << 20
+
+// regroups the complete lines of each block's main into whole records. the lines before the first
+// record start belong to a record that starts in an earlier block, so they are carried over to the end
+// of the next block processed (the one before it in the file). what is left in carry at the start of
+// the file has no record start at all
+func GetGroupRecordsReverseFunc(records core.Records, maxLength int, carry *[]byte, parseFunc func(uint64, parseBlock)) func(uint64, parseBlock) {
+	separator := records.Delimiter.Separator()
+	limit := maxLength
+	if limit <= 0 {
+		limit = maxCarriedLength
+	}
+	var dropped int64 // cut from the end of the carried lines, which carry then ends with a marker for
+	kept := 0         // bytes of carry before that marker
+	return func(index uint64, block parseBlock) {
+		if block.main == nil {
+			parseFunc(index, block)
+			return
+		}
+
+		// the carried lines were checked already, none of them is a record start
+		first := -1
+		count := uint64(0)
+		for start := 0; start < len(block.main); {
+			end := bytes.IndexByte(block.main[start:], separator)
+			if end == -1 {
+				end = len(block.main)
+			} else {
+				end += start + 1
+			}
+			if records.IsStart(block.main[start:end]) {
+				if first == -1 {
+					first = start
+				}
+				count++
+			}
+			start = end
+		}
+
+		if first == -1 {
+			lines := make([]byte, 0, len(block.main)+kept)
+			lines = append(append(lines, block.main...), (*carry)[:kept]...)
+			core_utils.PutBuffer(block.main)
+			*carry, kept, dropped = carryLines(lines, dropped, separator, limit)
+			block.main = nil
+			block.mainCount = 0
+			parseFunc(index, block)
+			return
+		}
+
+		main := block.main
+		if len(*carry) > 0 || first > 0 {
+			main = core_utils.GetBuffer(len(block.main) - first + len(*carry))
+			copy(main[copy(main, block.main[first:]):], *carry)
+			// copied, block.main is shared with no one
+			lines := append([]byte(nil), block.main[:first]...)
+			core_utils.PutBuffer(block.main)
+			*carry, kept, dropped = carryLines(lines, 0, separator, limit)
+		}
+		block.main = main
+		block.mainCount = count
+		parseFunc(index, block)
+	}
+}
+
+// lines cut down to limit bytes, followed by the truncation marker (see truncateLine) with the length
+// they had in all when anything was cut from them now or before (dropped); kept is how many are left
+func carryLines(lines []byte, dropped int64, separator byte, limit int) ([]byte, int, int64) {
+	if len(lines) > limit {
+		dropped += int64(len(lines) - limit)
+		lines = lines[:limit]
+	}
+	kept := len(lines)
+	if dropped == 0 {
+		return lines, kept, 0
+	}
+	carried := append(lines[:kept:kept], truncatedMarker...)
+	carried = strconv.AppendInt(carried, int64(kept)+dropped-1, 10)
+	carried = append(carried, " bytes]"...)
+	return append(carried, separator), kept, dropped
+}
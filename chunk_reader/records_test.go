@@ -0,0 +1,76 @@
+package chunk_reader
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"log_monitor/monitor/test_utils"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const trace = "\tat Foo.init(Foo.java:1)\n" +
+	"2021-02-25 10:00:00 start\n" +
+	"2021-02-25 10:00:01 java.lang.NullPointerException\n" +
+	"\tat Foo.bar(Foo.java:10)\n" +
+	"\tat Foo.main(Foo.java:3)\n" +
+	"2021-02-25 10:00:02 done\n"
+
+const exception = "2021-02-25 10:00:01 java.lang.NullPointerException\n" +
+	"\tat Foo.bar(Foo.java:10)\n" +
+	"\tat Foo.main(Foo.java:3)\n"
+
+func TestReadReverseRecords(t *testing.T) {
+	start := regexp.MustCompile(`^\d{4}-\d{2}-\d{2} `)
+	// the trace straddles chunks of every size
+	for _, chunk := range []int64{1, 2, 3, 5, 7, 16, 50, 64000} {
+		options := Options{Chunk: chunk, RecordStart: start}
+		reader := strings.NewReader(trace)
+
+		reader.Seek(0, io.SeekEnd)
+		res, err := ReadReverseNLinesWith(reader, 2, options, nil)
+		assert.Nil(t, err, chunk)
+		assert.Equal(t, "2021-02-25 10:00:02 done\n"+exception, test_utils.GetString(res), chunk)
+
+		reader.Seek(0, io.SeekEnd)
+		res, err = ReadReversePassesFilterWith(reader, "Foo.main", options, nil)
+		assert.Nil(t, err, chunk)
+		assert.Equal(t, exception, test_utils.GetString(res), chunk)
+
+		// continuation lines at the start of the file are a record of their own
+		reader.Seek(0, io.SeekEnd)
+		res, err = ReadReverseNLinesWith(reader, 4, options, nil)
+		assert.Nil(t, err, chunk)
+		assert.Equal(t, "2021-02-25 10:00:02 done\n"+exception+"2021-02-25 10:00:00 start\n\tat Foo.init(Foo.java:1)\n",
+			test_utils.GetString(res), chunk)
+
+		reader.Seek(0, io.SeekEnd)
+		res, err = ReadReversePassesFilterWith(reader, "Foo.init", options, nil)
+		assert.Nil(t, err, chunk)
+		assert.Equal(t, "\tat Foo.init(Foo.java:1)\n", test_utils.GetString(res), chunk)
+	}
+}
+
+// what is carried over to the next block is cut down, so a record start that doesn't match doesn't keep
+// the whole file
+func TestReadReverseRecords_Carried(t *testing.T) {
+	options := Options{Chunk: 16, RecordStart: regexp.MustCompile(`^NOMATCH`), MaxLength: 10}
+	reader := strings.NewReader(strings.Repeat("a\n", 1000))
+	reader.Seek(0, io.SeekEnd)
+	res, err := ReadReverseNLinesWith(reader, 1, options, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, strings.Repeat("a\n", 5)+"...[truncated, 1999 bytes]\n", test_utils.GetString(res))
+
+	options.RecordStart = regexp.MustCompile(`^\d{4} `)
+	options.MaxLength = 8
+	reader = strings.NewReader("2021 x\n" + strings.Repeat("\tat\n", 100) + "2022 y\n")
+	reader.Seek(0, io.SeekEnd)
+	res, err = ReadReverseNLinesWith(reader, 2, options, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "2022 y\n2021 x\n\tat\n\tat\n...[truncated, 399 bytes]\n", test_utils.GetString(res))
+
+	reader.Seek(0, io.SeekEnd)
+	res, err = ReadReversePassesFilterWith(reader, "2021", options, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "2021 x\n\tat\n\tat\n...[truncated, 399 bytes]\n", test_utils.GetString(res))
+}
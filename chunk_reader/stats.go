@@ -0,0 +1,24 @@
+package chunk_reader
+
+import "sync/atomic"
+
+// counts across every read in the process, for monitoring
+type Stats struct {
+	BytesRead   int64 // by ChunkRead
+	Chunks      int64 // handed to processChunk
+	Parsing     int64 // blocks being parsed right now, by the parse workers
+	Queued      int64 // blocks waiting for a parse worker
+	Truncations int64 // reads that failed on the file shrinking underneath them
+}
+
+var stats Stats
+
+func ReadStats() Stats {
+	return Stats{
+		BytesRead:   atomic.LoadInt64(&stats.BytesRead),
+		Chunks:      atomic.LoadInt64(&stats.Chunks),
+		Parsing:     atomic.LoadInt64(&stats.Parsing),
+		Queued:      atomic.LoadInt64(&stats.Queued),
+		Truncations: atomic.LoadInt64(&stats.Truncations),
+	}
+}
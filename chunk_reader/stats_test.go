@@ -0,0 +1,21 @@
+package chunk_reader
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadStats(t *testing.T) {
+	before := ReadStats()
+	reader := strings.NewReader("123\n456\n789\n")
+	reader.Seek(0, io.SeekEnd)
+	_, err := ReadReverseNLinesWith(reader, 3, Options{Chunk: 5}, nil)
+	assert.Nil(t, err)
+
+	after := ReadStats()
+	assert.Equal(t, int64(12), after.BytesRead-before.BytesRead)
+	assert.Equal(t, int64(3), after.Chunks-before.Chunks)
+	assert.Equal(t, int64(0), after.Truncations-before.Truncations)
+}
@@ -0,0 +1,82 @@
+package chunk_reader
+
+import (
+	"sync"
+	"time"
+)
+
+// paces reads to a number of bytes a second; one can be shared by any number of reads at once,
+// which then split the rate between them
+type Throttle struct {
+	bytesPerSecond int64
+	now            func() time.Time
+
+	mutex sync.Mutex
+	next  time.Time // when the bytes reserved so far have been paid for
+}
+
+// nil, no throttling, when bytesPerSecond isn't positive
+func NewThrottle(bytesPerSecond int64) *Throttle {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &Throttle{bytesPerSecond: bytesPerSecond, now: time.Now}
+}
+
+func (t *Throttle) BytesPerSecond() int64 {
+	return t.bytesPerSecond
+}
+
+// reserves n bytes and returns how long to wait before reading them
+func (t *Throttle) reserve(n int) time.Duration {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := t.now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	wait := t.next.Sub(now)
+	t.next = t.next.Add(time.Duration(float64(n) / float64(t.bytesPerSecond) * float64(time.Second)))
+	return wait
+}
+
+// called by ChunkReadPaced before every read; waits out the slowest of the throttles and reports it,
+// along with the bytes read
+func getPaceFunc(throttles []*Throttle, report *Report, sleep func(time.Duration)) func(int) {
+	var active []*Throttle
+	for _, throttle := range throttles {
+		if throttle != nil {
+			active = append(active, throttle)
+		}
+	}
+	if len(active) == 0 && report == nil {
+		return nil
+	}
+
+	if report != nil && len(active) > 0 {
+		report.ThrottleRate = active[0].bytesPerSecond
+		for _, throttle := range active[1:] {
+			if throttle.bytesPerSecond < report.ThrottleRate {
+				report.ThrottleRate = throttle.bytesPerSecond
+			}
+		}
+	}
+	return func(n int) {
+		if report != nil {
+			report.BytesRead += int64(n)
+		}
+		var wait time.Duration
+		for _, throttle := range active {
+			if w := throttle.reserve(n); w > wait {
+				wait = w
+			}
+		}
+		if wait > 0 {
+			sleep(wait)
+			if report != nil {
+				report.ThrottleWait += wait
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package chunk_reader
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"log_monitor/monitor/test_utils"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestThrottle(t *testing.T) {
+	assert.Nil(t, NewThrottle(0))
+
+	now := time.Unix(1000, 0)
+	throttle := NewThrottle(100)
+	throttle.now = func() time.Time { return now }
+
+	assert.Equal(t, time.Duration(0), throttle.reserve(50))
+	assert.Equal(t, 500*time.Millisecond, throttle.reserve(100))
+	assert.Equal(t, 1500*time.Millisecond, throttle.reserve(10))
+
+	// unused time isn't saved up
+	now = now.Add(time.Hour)
+	assert.Equal(t, time.Duration(0), throttle.reserve(100))
+	assert.Equal(t, time.Second, throttle.reserve(100))
+}
+
+func TestPaceFunc(t *testing.T) {
+	assert.Nil(t, getPaceFunc([]*Throttle{nil}, nil, nil))
+
+	now := time.Unix(1000, 0)
+	request := NewThrottle(100)
+	global := NewThrottle(50)
+	request.now = func() time.Time { return now }
+	global.now = request.now
+
+	var slept []time.Duration
+	var report Report
+	pace := getPaceFunc([]*Throttle{request, nil, global}, &report, func(d time.Duration) { slept = append(slept, d) })
+	assert.Equal(t, int64(50), report.ThrottleRate)
+
+	pace(100)
+	pace(100)
+	assert.Equal(t, []time.Duration{2 * time.Second}, slept)
+	assert.Equal(t, 2*time.Second, report.ThrottleWait)
+	assert.Equal(t, int64(200), report.BytesRead)
+
+	// reads are counted without throttles too
+	report = Report{}
+	pace = getPaceFunc(nil, &report, nil)
+	pace(100)
+	assert.Equal(t, int64(100), report.BytesRead)
+	assert.Equal(t, int64(0), report.ThrottleRate)
+}
+
+func TestChunkReadPaced(t *testing.T) {
+	reader := strings.NewReader("123456")
+	reader.Seek(0, io.SeekEnd)
+
+	var sizes []int
+	_, err := ChunkReadPaced(reader, 4, ReadBackward, func(n int) { sizes = append(sizes, n) }, func([]byte, int, uint64) {}, func() bool { return true })
+	assert.Nil(t, err)
+	assert.Equal(t, []int{4, 2}, sizes)
+}
+
+func TestReadReverseThrottled(t *testing.T) {
+	reader := strings.NewReader("abcdefghi\nabcdefghi\nabcdefghi\n")
+	reader.Seek(0, io.SeekEnd)
+
+	var report Report
+	start := time.Now()
+	res, err := ReadReversePassesFilterWith(reader, "a", Options{Chunk: 10, Throttles: []*Throttle{NewThrottle(1000)}}, &report)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(test_utils.GetLines(res)))
+	// 10 bytes are 10ms; the first read is free
+	assert.True(t, time.Since(start) >= 20*time.Millisecond)
+	assert.True(t, report.ThrottleWait >= 15*time.Millisecond)
+	assert.Equal(t, int64(1000), report.ThrottleRate)
+}
+
+func TestCancel(t *testing.T) {
+	reader := strings.NewReader("abcdefghi\nabcdefghi\nabcdefghi\n")
+	canceled := make(chan struct{})
+	close(canceled)
+
+	reader.Seek(0, io.SeekEnd)
+	_, err := ReadReverseNLinesWith(reader, 2, Options{Chunk: 10, Cancel: canceled}, nil)
+	assert.Equal(t, ErrCanceled, err)
+
+	// waiting on a throttle is cut short too
+	cancel := make(chan struct{})
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(cancel)
+	}()
+	reader.Seek(0, io.SeekEnd)
+	start := time.Now()
+	_, err = ReadReversePassesFilterWith(reader, "a", Options{Chunk: 10, Throttles: []*Throttle{NewThrottle(1)}, Cancel: cancel}, nil)
+	assert.Equal(t, ErrCanceled, err)
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
@@ -0,0 +1,62 @@
+package chunk_reader
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// a line longer than maxLength bytes (not counting its separator) is cut down to its first maxLength bytes,
+// followed by this and the line's full length
+const truncatedMarker = "...[truncated, "
+
+// line ends in its separator; dropped bytes were already cut from its middle (see keepLineHead)
+func truncateLine(line []byte, dropped int64, separator byte, maxLength int) []byte {
+	length := int64(len(line)-1) + dropped
+	if maxLength <= 0 || length <= int64(maxLength) {
+		return line
+	}
+
+	truncated := make([]byte, 0, maxLength+len(truncatedMarker)+32)
+	truncated = append(truncated, line[:maxLength]...)
+	truncated = append(truncated, truncatedMarker...)
+	truncated = strconv.AppendInt(truncated, length, 10)
+	truncated = append(truncated, " bytes]"...)
+	return append(truncated, separator)
+}
+
+// keeps the first maxLength bytes and the separator of a line that isn't complete yet (its start is in
+// an earlier block), counting what is cut. the head is kept rather than the tail since more of the line is
+// yet to be prepended
+func keepLineHead(line []byte, dropped int64, maxLength int) ([]byte, int64) {
+	if maxLength <= 0 || len(line)-1 <= maxLength {
+		return line, dropped
+	}
+	dropped += int64(len(line) - 1 - maxLength)
+	return append(line[:maxLength:maxLength], line[len(line)-1]), dropped
+}
+
+// truncates every line of main that is too long
+func truncateLines(main []byte, separator byte, maxLength int) []byte {
+	if maxLength <= 0 || len(main) <= maxLength {
+		return main
+	}
+
+	var truncated []byte
+	copied := 0
+	for start := 0; start < len(main); {
+		end := start + bytes.IndexByte(main[start:], separator) + 1
+		if end-start-1 > maxLength {
+			if truncated == nil {
+				truncated = make([]byte, 0, len(main))
+			}
+			truncated = append(truncated, main[copied:start]...)
+			truncated = append(truncated, truncateLine(main[start:end], 0, separator, maxLength)...)
+			copied = end
+		}
+		start = end
+	}
+	if truncated == nil {
+		return main
+	}
+	return append(truncated, main[copied:]...)
+}
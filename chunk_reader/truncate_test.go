@@ -0,0 +1,72 @@
+package chunk_reader
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"log_monitor/monitor/test_utils"
+	"strings"
+	"testing"
+)
+
+func TestTruncateLine(t *testing.T) {
+	assert.Equal(t, "abc\n", string(truncateLine([]byte("abc\n"), 0, '\n', 3)))
+	assert.Equal(t, "abc\n", string(truncateLine([]byte("abc\n"), 0, '\n', 0)))
+	assert.Equal(t, "ab...[truncated, 3 bytes]\n", string(truncateLine([]byte("abc\n"), 0, '\n', 2)))
+	assert.Equal(t, "ab...[truncated, 13 bytes]\n", string(truncateLine([]byte("abc\n"), 10, '\n', 2)))
+}
+
+func TestKeepLineHead(t *testing.T) {
+	head, dropped := keepLineHead([]byte("abc\n"), 0, 3)
+	assert.Equal(t, "abc\n", string(head))
+	assert.Equal(t, int64(0), dropped)
+
+	head, dropped = keepLineHead([]byte("abcdef\n"), 2, 3)
+	assert.Equal(t, "abc\n", string(head))
+	assert.Equal(t, int64(5), dropped)
+}
+
+func TestTruncateLines(t *testing.T) {
+	main := []byte("a\nbcdef\ng\nhijkl\n")
+	assert.Equal(t, "a\nbc...[truncated, 5 bytes]\ng\nhi...[truncated, 5 bytes]\n", string(truncateLines(main, '\n', 2)))
+	assert.Equal(t, string(main), string(truncateLines(main, '\n', 5)))
+	assert.Equal(t, string(main), string(truncateLines(main, '\n', 0)))
+}
+
+func TestMaxLength(t *testing.T) {
+	long := strings.Repeat("0123456789", 10)
+	contents := "first\n" + long + "\nshort\n" + long + "\n"
+	truncated := "0123456789...[truncated, 100 bytes]\n"
+	for _, chunk := range []int64{1, 2, 3, 7, 10, 11, 64, 10000} {
+		reader := strings.NewReader(contents)
+
+		reader.Seek(0, io.SeekEnd)
+		res, err := ReadReverseNLinesWith(reader, 4, Options{Chunk: chunk, MaxLength: 10}, nil)
+		assert.Nil(t, err, chunk)
+		assert.Equal(t, truncated+"short\n"+truncated+"first\n", test_utils.GetString(res), chunk)
+
+		reader.Seek(0, io.SeekEnd)
+		res, err = ReadReversePassesFilterWith(reader, "ort", Options{Chunk: chunk, MaxLength: 10}, nil)
+		assert.Nil(t, err, chunk)
+		assert.Equal(t, "short\n", test_utils.GetString(res), chunk)
+
+		// the first line of the file
+		reader = strings.NewReader(long + "\n")
+		reader.Seek(0, io.SeekEnd)
+		res, err = ReadReverseNLinesWith(reader, 1, Options{Chunk: chunk, MaxLength: 10}, nil)
+		assert.Nil(t, err, chunk)
+		assert.Equal(t, truncated, test_utils.GetString(res), chunk)
+	}
+}
+
+func TestMaxLength_HoleOffsets(t *testing.T) {
+	// holes are still found at their offset in the file, past a truncated line
+	for _, chunk := range []int64{1, 3, 10000} {
+		reader := strings.NewReader(strings.Repeat("x", 20) + "\n\x00\x00a\n")
+		reader.Seek(0, io.SeekEnd)
+		var report Report
+		res, err := ReadReverseNLinesWith(reader, 2, Options{Chunk: chunk, MaxLength: 5, SkipHoles: true}, &report)
+		assert.Nil(t, err, chunk)
+		assert.Equal(t, "a\nxxxxx...[truncated, 20 bytes]\n", test_utils.GetString(res), chunk)
+		assert.Equal(t, []Hole{{Offset: 21, Length: 2}}, report.Holes, chunk)
+	}
+}
@@ -0,0 +1,51 @@
+package chunk_reader
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// blocks queued per worker; once the queue is full, handing over a block waits for a worker, and with
+// it the ChunkRead that read the block, so reading from disk keeps pace with parsing instead of piling
+// blocks up in memory
+const parseQueuePerWorker = 2
+
+// a fixed set of goroutines parsing the blocks of every read in the process, in the order handed over
+type parseWorkers struct {
+	queue chan func()
+}
+
+func newParseWorkers(count int, queueLength int) *parseWorkers {
+	w := &parseWorkers{queue: make(chan func(), queueLength)}
+	for i := 0; i < count; i++ {
+		go w.work()
+	}
+	return w
+}
+
+var workers *parseWorkers
+var startWorkers sync.Once
+
+// hands parse over to the workers, as many as GOMAXPROCS, started on first use; blocks while the queue is full
+func queueParse(parse func()) {
+	startWorkers.Do(func() {
+		count := runtime.GOMAXPROCS(0)
+		workers = newParseWorkers(count, count*parseQueuePerWorker)
+	})
+	workers.run(parse)
+}
+
+func (w *parseWorkers) run(parse func()) {
+	atomic.AddInt64(&stats.Queued, 1)
+	w.queue <- parse
+}
+
+func (w *parseWorkers) work() {
+	for parse := range w.queue {
+		atomic.AddInt64(&stats.Queued, -1)
+		atomic.AddInt64(&stats.Parsing, 1)
+		parse()
+		atomic.AddInt64(&stats.Parsing, -1)
+	}
+}
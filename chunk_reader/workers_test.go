@@ -0,0 +1,48 @@
+package chunk_reader
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseWorkers_Backpressure(t *testing.T) {
+	w := newParseWorkers(1, 1)
+	release := make(chan struct{})
+	done := make(chan int, 3)
+	for i := 0; i < 2; i++ {
+		i := i
+		w.run(func() {
+			<-release
+			done <- i
+		})
+	}
+
+	// one being parsed, one queued; the third waits for room
+	handedOver := make(chan struct{})
+	go func() {
+		w.run(func() { done <- 2 })
+		close(handedOver)
+	}()
+	select {
+	case <-handedOver:
+		t.Fatal("handed over to a full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	<-handedOver
+	assert.Equal(t, []int{0, 1, 2}, []int{<-done, <-done, <-done})
+}
+
+func TestParseWorkers_ManyBlocks(t *testing.T) {
+	lines := strings.Repeat("0123456789\n", 10000)
+	reader := strings.NewReader(lines)
+	reader.Seek(0, io.SeekEnd)
+	// far more blocks than there is room for in the queue
+	res, err := ReadReversePassesFilterWith(reader, "0123", Options{Chunk: 64}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(lines)), res.(interface{ Size() int64 }).Size())
+}
@@ -0,0 +1,55 @@
+package core
+
+import "errors"
+
+// what ends a record: a single byte, or "\r\n" (the '\r' is dropped from what gets returned).
+// empty is '\n'
+type Delimiter string
+
+const NewLine = Delimiter("")
+const CRLF = Delimiter("\r\n")
+const NulSeparated = Delimiter("\x00")
+
+// takes lf, crlf, nul, or a single byte; name is as it was given, already decoded from the query
+// (or the config file)
+func ParseDelimiter(name string) (Delimiter, error) {
+	switch name {
+	case "", "lf":
+		return NewLine, nil
+	case "crlf":
+		return CRLF, nil
+	case "nul":
+		return NulSeparated, nil
+	}
+
+	if len(name) != 1 {
+		return NewLine, errors.New("delimiter must be lf, crlf, nul or a single byte")
+	}
+	if name == "\n" {
+		return NewLine, nil
+	}
+	return Delimiter(name), nil
+}
+
+// the byte records are split on
+func (d Delimiter) Separator() byte {
+	if len(d) == 0 {
+		return '\n'
+	}
+	return d[len(d)-1]
+}
+
+// the delimiter records are returned with
+func (d Delimiter) Output() Delimiter {
+	if d == CRLF {
+		return NewLine
+	}
+	return d
+}
+
+func (d Delimiter) stripRecord(record string) string {
+	if d == CRLF && len(record) >= 2 && record[len(record)-2] == '\r' && record[len(record)-1] == '\n' {
+		return record[:len(record)-2] + "\n"
+	}
+	return record
+}
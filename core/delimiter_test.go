@@ -0,0 +1,95 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"log_monitor/monitor/test_utils"
+	"strings"
+	"testing"
+)
+
+func atEnd(contents string) io.ReadSeeker {
+	reader := strings.NewReader(contents)
+	reader.Seek(0, io.SeekEnd)
+	return reader
+}
+
+func TestParseDelimiter(t *testing.T) {
+	valid := map[string]Delimiter{
+		"":     NewLine,
+		"lf":   NewLine,
+		"\n":   NewLine,
+		"crlf": CRLF,
+		"nul":  NulSeparated,
+		"\x00": NulSeparated,
+		",":    Delimiter(","),
+		"|":    Delimiter("|"),
+		"%":    Delimiter("%"),
+		"+":    Delimiter("+"),
+	}
+	for name, expected := range valid {
+		delimiter, err := ParseDelimiter(name)
+		assert.Nil(t, err, name)
+		assert.Equal(t, expected, delimiter, name)
+	}
+
+	// decoded already, not again
+	for _, name := range []string{"ab", "%zz", "cr%0A", "%7C"} {
+		_, err := ParseDelimiter(name)
+		assert.NotNil(t, err, name)
+	}
+}
+
+func TestDelimiter(t *testing.T) {
+	assert.Equal(t, byte('\n'), NewLine.Separator())
+	assert.Equal(t, byte('\n'), CRLF.Separator())
+	assert.Equal(t, byte(0), NulSeparated.Separator())
+	assert.Equal(t, byte(','), Delimiter(",").Separator())
+
+	assert.Equal(t, NewLine, CRLF.Output())
+	assert.Equal(t, NulSeparated, NulSeparated.Output())
+
+	assert.Equal(t, "abc\n", CRLF.stripRecord("abc\r\n"))
+	assert.Equal(t, "abc\n", CRLF.stripRecord("abc\n"))
+	assert.Equal(t, "\r", CRLF.stripRecord("\r"))
+	assert.Equal(t, "abc\r\n", NewLine.stripRecord("abc\r\n"))
+}
+
+func TestReadReverseDelimited(t *testing.T) {
+	for _, sanitary := range []bool{true, false} {
+		t.Run("crlf", func(t *testing.T) {
+			res, err := readReverseNLinesHelper(atEnd("abc\r\ndef\r\nghi\n"), 3, Records{Delimiter: CRLF}, sanitary)
+			assert.Nil(t, err)
+			assert.Equal(t, "ghi\ndef\nabc\n", test_utils.GetString(res))
+
+			res, err = readReversePassesFilterHelper(atEnd("abc\r\ndef\r\nghi\n"), "e", Records{Delimiter: CRLF}, sanitary)
+			assert.Nil(t, err)
+			assert.Equal(t, "def\n", test_utils.GetString(res))
+		})
+
+		t.Run("nul", func(t *testing.T) {
+			res, err := readReverseNLinesHelper(atEnd("abc\x00d\nef\x00ghi\x00"), 2, Records{Delimiter: NulSeparated}, sanitary)
+			assert.Nil(t, err)
+			assert.Equal(t, "ghi\x00d\nef\x00", test_utils.GetString(res))
+		})
+
+		t.Run("custom", func(t *testing.T) {
+			res, err := readReversePassesFilterHelper(atEnd("a,b,ab,"), "a", Records{Delimiter: Delimiter(",")}, sanitary)
+			assert.Nil(t, err)
+			assert.Equal(t, "ab,a,", test_utils.GetString(res))
+		})
+	}
+}
+
+// a block handed over by the chunk reader can start with an empty record
+func TestReadReverseLeadingEmptyRecord(t *testing.T) {
+	for _, sanitary := range []bool{true, false} {
+		res, err := readReverseNLinesHelper(atEnd("\nef\n"), 2, Records{Delimiter: NewLine}, sanitary)
+		assert.Nil(t, err)
+		assert.Equal(t, "ef\n\n", test_utils.GetString(res))
+
+		res, err = readReverseNLinesHelper(atEnd("\x00\x00ef\x00"), 3, Records{Delimiter: NulSeparated}, sanitary)
+		assert.Nil(t, err)
+		assert.Equal(t, "ef\x00\x00\x00", test_utils.GetString(res))
+	}
+}
@@ -2,30 +2,47 @@ package core
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"log_monitor/monitor/core_utils"
 	"strings"
 )
 
 func ReadReverseNLinesFast(buffer io.ReadSeeker, numLines uint64) (io.ReadSeeker, error) {
-	return readReverseNLinesHelper(buffer, numLines, true)
+	return readReverseNLinesHelper(buffer, numLines, Records{}, true)
 }
 
 func ReadReverseNLines(buffer io.ReadSeeker, numLines uint64) (io.ReadSeeker, error) {
-	return readReverseNLinesHelper(buffer, numLines, false)
+	return readReverseNLinesHelper(buffer, numLines, Records{}, false)
 }
 
 func ReadReversePassesFilter(buffer io.ReadSeeker, expr string) (io.ReadSeeker, error) {
-	return readReversePassesFilterHelper(buffer, expr, false)
+	return readReversePassesFilterHelper(buffer, expr, Records{}, false)
 }
 
 func ReadReversePassesFilterFast(buffer io.ReadSeeker, expr string) (io.ReadSeeker, error) {
-	return readReversePassesFilterHelper(buffer, expr, true)
+	return readReversePassesFilterHelper(buffer, expr, Records{}, true)
+}
+
+func ReadReverseNRecordsFast(buffer io.ReadSeeker, numRecords uint64, records Records) (io.ReadSeeker, error) {
+	return readReverseNLinesHelper(buffer, numRecords, records, true)
+}
+
+func ReadReverseNRecords(buffer io.ReadSeeker, numRecords uint64, records Records) (io.ReadSeeker, error) {
+	return readReverseNLinesHelper(buffer, numRecords, records, false)
+}
+
+func ReadReverseRecordsPassFilter(buffer io.ReadSeeker, expr string, records Records) (io.ReadSeeker, error) {
+	return readReversePassesFilterHelper(buffer, expr, records, false)
+}
+
+func ReadReverseRecordsPassFilterFast(buffer io.ReadSeeker, expr string, records Records) (io.ReadSeeker, error) {
+	return readReversePassesFilterHelper(buffer, expr, records, true)
 }
 
 // sanitary flag true expects perfect new lines;
 // does not handle any concurrent changes to file (truncation)
-func readReversePassesFilterHelper(buffer io.ReadSeeker, expr string, sanitary bool) (io.ReadSeeker, error) {
+func readReversePassesFilterHelper(buffer io.ReadSeeker, expr string, records Records, sanitary bool) (io.ReadSeeker, error) {
 	validFunc := func(line string) bool {
 		return strings.Contains(line, expr)
 	}
@@ -35,16 +52,12 @@ func readReversePassesFilterHelper(buffer io.ReadSeeker, expr string, sanitary b
 		return pos > 0, err
 	}
 
-	if sanitary {
-		return readReverse(readLineReverseFast, buffer, validFunc, keepReadingFunc)
-	} else {
-		return readReverse(readLineReverse, buffer, validFunc, keepReadingFunc)
-	}
+	return readReverse(getRecordReader(records, sanitary), buffer, validFunc, keepReadingFunc)
 }
 
 // sanitary flag true expects perfect new lines;
 // does not handle any concurrent changes to file (truncation)
-func readReverseNLinesHelper(buffer io.ReadSeeker, numLines uint64, sanitary bool) (io.ReadSeeker, error) {
+func readReverseNLinesHelper(buffer io.ReadSeeker, numLines uint64, records Records, sanitary bool) (io.ReadSeeker, error) {
 	if numLines == 0 {
 		return nil, nil
 	}
@@ -58,100 +71,168 @@ func readReverseNLinesHelper(buffer io.ReadSeeker, numLines uint64, sanitary boo
 		return count < numLines, nil
 	}
 
-	if sanitary {
-		return readReverse(readLineReverseFast, buffer, validFunc, keepReadingFunc)
-	} else {
-		return readReverse(readLineReverse, buffer, validFunc, keepReadingFunc)
-	}
+	return readReverse(getRecordReader(records, sanitary), buffer, validFunc, keepReadingFunc)
 }
 
 type reverseLineReader func(io.ReadSeeker) (string, error)
 
+// the results are in a pooled buffer (see core_utils.PooledReader). it starts out small and grows with
+// them, what is read from can be a whole file
 func readReverse(reader reverseLineReader, buffer io.ReadSeeker, isValid func(string) bool, keepReading func() (bool, error)) (io.ReadSeeker, error) {
-	var results bytes.Buffer
+	size, err := buffer.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	if size > fastBlockSize {
+		size = fastBlockSize
+	}
+	results := core_utils.GetBuffer(int(size))[:0]
 	for {
 		line, err := reader(buffer)
 		if err != nil {
+			core_utils.PutBuffer(results)
 			return nil, err
 		} else if isValid(line) {
-			results.WriteString(line)
+			results = appendPooled(results, line)
 		}
 
 		ok, err := keepReading()
 		if err != nil {
+			core_utils.PutBuffer(results)
 			return nil, err
 		} else if !ok {
 			break
 		}
 	}
-	return bytes.NewReader(results.Bytes()), nil
+	return core_utils.NewPooledReader(results), nil
 }
 
-// this assumes buffer doesn't change and has perfect lines
-func readLineReverseFast(buffer io.ReadSeeker) (string, error) {
-	var reverseBuffer []byte
-	write := func(start int64, end int64) {
-		reverseBuffer = make([]byte, end-start)
-		buffer.Read(reverseBuffer)
-		buffer.Seek(start-end, io.SeekCurrent)
+// appends to a pooled buffer, moving to one twice as big when it's full
+func appendPooled(results []byte, line string) []byte {
+	if len(results)+len(line) <= cap(results) {
+		return append(results, line...)
 	}
-
-	end, _ := buffer.Seek(0, io.SeekCurrent)
-	foundNewLine := false
-	for {
-		pos, err := buffer.Seek(-1, io.SeekCurrent)
-		if err != nil {
-			return "", err
-		} else if pos == 0 {
-			write(int64(0), end)
-			break
-		}
-
-		var charBuffer [1]byte
-		buffer.Read(charBuffer[:])
-		if charBuffer[0] == '\n' {
-			if !foundNewLine {
-				foundNewLine = true
-			} else {
-				start, _ := buffer.Seek(0, io.SeekCurrent)
-				write(start, end)
-				break
-			}
-		}
-		buffer.Seek(-1, io.SeekCurrent)
+	size := 2 * cap(results)
+	if size < len(results)+len(line) {
+		size = len(results) + len(line)
 	}
-	return string(reverseBuffer), nil
+	grown := append(core_utils.GetBuffer(size)[:0], results...)
+	core_utils.PutBuffer(results)
+	return append(grown, line...)
+}
+
+func readLineReverseFast(buffer io.ReadSeeker) (string, error) {
+	return readRecordReverseFast(buffer, '\n')
 }
 
 func readLineReverse(buffer io.ReadSeeker) (string, error) {
-	newlineFound := false
+	return readRecordReverse(buffer, '\n')
+}
 
-	var reverseBuffer []byte
-	for {
-		pos, err := buffer.Seek(-1, io.SeekCurrent)
-		if err != nil {
-			return "", err
-		}
+// this assumes buffer doesn't change and has perfect lines
+func readRecordReverseFast(buffer io.ReadSeeker, separator byte) (string, error) {
+	return newRecordReaderFast(separator)(buffer)
+}
 
-		var charBuffer [1]byte
-		if _, err := buffer.Read(charBuffer[:]); err != nil {
-			return "", err
-		}
+// the block read for a record is kept for the records before it, so this assumes buffer doesn't change
+// either
+func newRecordReaderFast(separator byte) reverseLineReader {
+	scanner := reverseScanner{separator: separator, block: fastBlockSize}
+	return scanner.readRecord
+}
 
-		if charBuffer[0] == '\n' {
-			if newlineFound {
+// every record is read from buffer again, so a read that comes up short (the file shrank) fails it
+func readRecordReverse(buffer io.ReadSeeker, separator byte) (string, error) {
+	scanner := reverseScanner{separator: separator, block: blockSize}
+	return scanner.readRecord(buffer)
+}
+
+const fastBlockSize = 4096
+
+// most lines fit, the block grows for those that don't
+const blockSize = 256
+
+var errAtStart = errors.New("no record before the start of the buffer")
+
+// reads the record ending where buffer is, a block at a time, and leaves buffer at its start. a record
+// runs from after the second separator before the end (the first is usually its own) or from the start
+type reverseScanner struct {
+	separator byte
+	block     int
+	cached    []byte // read, and not yet returned, up to cachedEnd
+	cachedEnd int64
+	spare     []byte // the buffer cached was in before the last block was read
+}
+
+func (s *reverseScanner) readRecord(buffer io.ReadSeeker) (string, error) {
+	end, err := buffer.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", err
+	} else if end == 0 {
+		return "", errAtStart
+	}
+	if end != s.cachedEnd {
+		// moved by someone else
+		s.cached, s.cachedEnd = s.cached[:0], end
+	}
+
+	foundSeparator := false
+	searched := 0 // from the end of cached
+	for {
+		window := s.cached[:len(s.cached)-searched]
+		for {
+			i := bytes.LastIndexByte(window, s.separator)
+			if i == -1 {
 				break
-			} else {
-				newlineFound = true
+			} else if foundSeparator {
+				return s.take(buffer, i+1)
 			}
+			foundSeparator = true
+			window = window[:i]
 		}
-		buffer.Seek(-1, io.SeekCurrent)
+		searched = len(s.cached)
 
-		reverseBuffer = append(reverseBuffer, charBuffer[0])
-		if pos == 0 {
-			break
+		if s.cachedEnd-int64(len(s.cached)) == 0 {
+			return s.take(buffer, 0)
+		} else if err := s.readBlock(buffer); err != nil {
+			return "", err
 		}
 	}
+}
+
+// the record is cached from start on
+func (s *reverseScanner) take(buffer io.ReadSeeker, start int) (string, error) {
+	record := string(s.cached[start:])
+	s.cachedEnd -= int64(len(s.cached) - start)
+	s.cached = s.cached[:start]
+	if _, err := buffer.Seek(s.cachedEnd, io.SeekStart); err != nil {
+		return "", err
+	}
+	return record, nil
+}
 
-	return string(core_utils.ReverseBytes(reverseBuffer)), nil
+// reads the block before what is cached in front of it
+func (s *reverseScanner) readBlock(buffer io.ReadSeeker) error {
+	start := s.cachedEnd - int64(len(s.cached))
+	n := s.block
+	if n < len(s.cached) {
+		// a long record, don't read it again and again a block at a time
+		n = len(s.cached)
+	}
+	if int64(n) > start {
+		n = int(start)
+	}
+
+	next := s.spare[:0]
+	if cap(next) < n+len(s.cached) {
+		next = make([]byte, 0, n+len(s.cached))
+	}
+	next = next[:n]
+	if _, err := buffer.Seek(start-int64(n), io.SeekStart); err != nil {
+		return err
+	} else if _, err := io.ReadFull(buffer, next); err != nil {
+		return err
+	}
+	s.spare, s.cached = s.cached, append(next, s.cached...)
+	return nil
 }
@@ -7,6 +7,7 @@ import (
 	"io"
 	"log_monitor/monitor/core_utils"
 	"log_monitor/monitor/test_utils"
+	"runtime"
 	"strings"
 	"testing"
 )
@@ -427,3 +428,45 @@ func TestReadReversePassesFilter_NotEmpty(t *testing.T) {
 	}()
 
 }
+
+// records longer than a block, and blocks ending in the middle of records
+func TestReadRecordReverse_Blocks(t *testing.T) {
+	lines := []string{"\n", strings.Repeat("a", 5000) + "\n", "b\n", strings.Repeat("c", 300) + "\n", "\n"}
+	contents := strings.Join(lines, "")
+	for _, readLine := range []reverseLineReader{readLineReverse, newRecordReaderFast('\n')} {
+		reader := atEnd(contents + strings.Repeat("e\n", 1000))
+		for i := 0; i < 1000; i++ {
+			line, err := readLine(reader)
+			assert.Nil(t, err)
+			assert.Equal(t, "e\n", line)
+		}
+		expected := []string{"\n", strings.Repeat("c", 300) + "\n", "b\n", strings.Repeat("a", 5000) + "\n", "\n"}
+		for _, record := range expected {
+			line, err := readLine(reader)
+			assert.Nil(t, err)
+			assert.Equal(t, record, line)
+		}
+		pos, err := reader.Seek(0, io.SeekCurrent)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(0), pos)
+		_, err = readLine(reader)
+		assert.NotNil(t, err)
+	}
+}
+
+// the results grow with what is kept, not with what there is to read from
+func TestReadReverse_ResultsGrow(t *testing.T) {
+	// past the biggest pooled buffer
+	reader := atEnd(strings.Repeat("0123456789\n", 2<<20))
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	res, err := ReadReverseNLines(reader, 10)
+	runtime.ReadMemStats(&after)
+	assert.Nil(t, err)
+	assert.Equal(t, strings.Repeat("0123456789\n", 10), test_utils.GetString(res))
+	assert.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(1<<20))
+
+	results := appendPooled(core_utils.GetBuffer(512)[:0], strings.Repeat("a", 1000))
+	results = appendPooled(results, "b")
+	assert.Equal(t, strings.Repeat("a", 1000)+"b", string(results))
+}
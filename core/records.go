@@ -0,0 +1,79 @@
+package core
+
+import (
+	"io"
+	"regexp"
+	"strings"
+)
+
+// how a buffer is split into records
+type Records struct {
+	Delimiter Delimiter
+	// a record is a line matching Start followed by every line up to the next match (stack traces,
+	// continuation lines); nil is one line per record. lines before the first match are a record of their own
+	Start *regexp.Regexp
+}
+
+// the records returned use the output delimiter
+func (r Records) Output() Records {
+	return Records{Delimiter: r.Delimiter.Output(), Start: r.Start}
+}
+
+// whether line (with its delimiter) starts a record
+func (r Records) IsStart(line []byte) bool {
+	if r.Start == nil {
+		return true
+	}
+	if len(line) > 0 && line[len(line)-1] == r.Delimiter.Separator() {
+		line = line[:len(line)-1]
+	}
+	if r.Delimiter == CRLF && len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return r.Start.Match(line)
+}
+
+func getRecordReader(records Records, sanitary bool) reverseLineReader {
+	separator := records.Delimiter.Separator()
+	readRecord := func(buffer io.ReadSeeker) (string, error) {
+		return readRecordReverse(buffer, separator)
+	}
+	if sanitary {
+		readRecord = newRecordReaderFast(separator)
+	}
+	readLine := func(buffer io.ReadSeeker) (string, error) {
+		line, err := readRecord(buffer)
+		return records.Delimiter.stripRecord(line), err
+	}
+	if records.Start == nil {
+		return readLine
+	}
+
+	// lines are read backwards until the one starting the record
+	return func(buffer io.ReadSeeker) (string, error) {
+		var lines []string
+		for {
+			line, err := readLine(buffer)
+			if err != nil {
+				return "", err
+			}
+			lines = append(lines, line)
+			if records.IsStart([]byte(line)) {
+				break
+			}
+
+			pos, err := buffer.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return "", err
+			} else if pos == 0 {
+				break
+			}
+		}
+
+		var record strings.Builder
+		for i := len(lines) - 1; i >= 0; i-- {
+			record.WriteString(lines[i])
+		}
+		return record.String(), nil
+	}
+}
@@ -0,0 +1,51 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"log_monitor/monitor/test_utils"
+	"regexp"
+	"testing"
+)
+
+const trace = "2021-02-25 10:00:00 start\n" +
+	"2021-02-25 10:00:01 java.lang.NullPointerException\n" +
+	"\tat Foo.bar(Foo.java:10)\n" +
+	"\tat Foo.main(Foo.java:3)\n" +
+	"2021-02-25 10:00:02 done\n"
+
+func TestIsStart(t *testing.T) {
+	assert.True(t, Records{}.IsStart([]byte("anything\n")))
+
+	records := Records{Start: regexp.MustCompile(`^\d{4}-\d{2}-\d{2} .*done$`)}
+	assert.True(t, records.IsStart([]byte("2021-02-25 done\n")))
+	assert.False(t, records.IsStart([]byte("\tat Foo.bar\n")))
+
+	records.Delimiter = CRLF
+	assert.True(t, records.IsStart([]byte("2021-02-25 done\r\n")))
+}
+
+func TestReadReverseRecords(t *testing.T) {
+	records := Records{Start: regexp.MustCompile(`^\d{4}-\d{2}-\d{2} `)}
+	for _, sanitary := range []bool{true, false} {
+		res, err := readReverseNLinesHelper(atEnd(trace), 2, records, sanitary)
+		assert.Nil(t, err)
+		assert.Equal(t, "2021-02-25 10:00:02 done\n"+
+			"2021-02-25 10:00:01 java.lang.NullPointerException\n\tat Foo.bar(Foo.java:10)\n\tat Foo.main(Foo.java:3)\n",
+			test_utils.GetString(res))
+
+		res, err = readReversePassesFilterHelper(atEnd(trace), "Foo.main", records, sanitary)
+		assert.Nil(t, err)
+		assert.Equal(t, "2021-02-25 10:00:01 java.lang.NullPointerException\n\tat Foo.bar(Foo.java:10)\n\tat Foo.main(Foo.java:3)\n",
+			test_utils.GetString(res))
+
+		// continuation lines at the top, without a start, are their own record
+		res, err = readReverseNLinesHelper(atEnd("\tat Foo.main(Foo.java:3)\n"+trace), 4, records, sanitary)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"2021-02-25 10:00:02 done\n",
+			"2021-02-25 10:00:01 java.lang.NullPointerException\n",
+			"\tat Foo.bar(Foo.java:10)\n",
+			"\tat Foo.main(Foo.java:3)\n",
+			"2021-02-25 10:00:00 start\n",
+			"\tat Foo.main(Foo.java:3)\n"}, test_utils.GetLines(res))
+	}
+}
@@ -0,0 +1,27 @@
+package core_utils
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+const hexDigits = "0123456789abcdef"
+
+// non printable bytes (other than new lines and tabs) and invalid utf8 become \xNN
+func EscapeNonPrintable(buffer []byte) []byte {
+	escaped := make([]byte, 0, len(buffer))
+	for i := 0; i < len(buffer); {
+		r, size := utf8.DecodeRune(buffer[i:])
+		if r == '\n' || r == '\t' || (r != utf8.RuneError && unicode.IsPrint(r)) {
+			escaped = append(escaped, buffer[i:i+size]...)
+			i += size
+			continue
+		}
+		// invalid utf8 has a size of 1; escape the rest one at a time
+		for _, c := range buffer[i : i+size] {
+			escaped = append(escaped, '\\', 'x', hexDigits[c>>4], hexDigits[c&0xf])
+		}
+		i += size
+	}
+	return escaped
+}
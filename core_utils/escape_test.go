@@ -0,0 +1,15 @@
+package core_utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestEscapeNonPrintable(t *testing.T) {
+	assert.Equal(t, []byte{}, EscapeNonPrintable(nil))
+	assert.Equal(t, "abc\tdef\n", string(EscapeNonPrintable([]byte("abc\tdef\n"))))
+	assert.Equal(t, "café 日本\n", string(EscapeNonPrintable([]byte("café 日本\n"))))
+	assert.Equal(t, `a\x00b\x07\x1b[0m`+"\n", string(EscapeNonPrintable([]byte("a\x00b\x07\x1b[0m\n"))))
+	assert.Equal(t, `\xff\xfe\xc3`, string(EscapeNonPrintable([]byte{0xff, 0xfe, 0xc3})))
+	assert.Equal(t, `line\x0d`+"\n", string(EscapeNonPrintable([]byte("line\r\n"))))
+}
@@ -0,0 +1,156 @@
+package core_utils
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Buffers come from size classes, powers of two from minBufferClass to maxBufferClass bytes; bigger ones
+// aren't pooled.
+//
+// Ownership: whoever gets a buffer owns it, and can hand it on (with everything that refers into it).
+// The last owner puts it back, after which nothing may touch it. A buffer that is never put back is
+// just garbage collected, so handing one to code that doesn't know about the pool is fine; putting back
+// a buffer something else still refers to is not.
+const minBufferClass = 1 << 9
+const maxBufferClass = 1 << 24
+
+// free buffers kept, in bytes, all classes together; whichever class puts a buffer back first gets the room
+const maxFreeBytes = 64 << 20
+
+// free buffers of a class nobody has asked for in this long are dropped
+const BufferIdleTimeout = 30 * time.Second
+
+type sizeClass struct {
+	size int
+
+	mutex sync.Mutex
+	free  [][]byte
+	used  time.Time // last get
+}
+
+type bufferPool struct {
+	free    int64 // bytes in the classes' free buffers, or about to be; first for atomic alignment
+	classes []*sizeClass
+	now     func() time.Time
+	shrink  sync.Once
+}
+
+func newBufferPool() *bufferPool {
+	pool := &bufferPool{now: time.Now}
+	for size := minBufferClass; size <= maxBufferClass; size <<= 1 {
+		pool.classes = append(pool.classes, &sizeClass{size: size})
+	}
+	return pool
+}
+
+var buffers = newBufferPool()
+
+// a buffer of length n, with whatever it held before
+func GetBuffer(n int) []byte {
+	return buffers.get(n)
+}
+
+// b has to have come from GetBuffer (resliced from the start is fine); anything else is dropped
+func PutBuffer(b []byte) {
+	buffers.put(b)
+}
+
+// counts the free buffers and the bytes they take, for monitoring
+func PooledBuffers() (int, int64) {
+	return buffers.count()
+}
+
+func (p *bufferPool) class(n int) *sizeClass {
+	for _, class := range p.classes {
+		if n <= class.size {
+			return class
+		}
+	}
+	return nil
+}
+
+func (p *bufferPool) get(n int) []byte {
+	class := p.class(n)
+	if class == nil {
+		return make([]byte, n)
+	}
+	p.shrink.Do(func() { go p.shrinkIdle(BufferIdleTimeout) })
+
+	class.mutex.Lock()
+	defer class.mutex.Unlock()
+	class.used = p.now()
+	if last := len(class.free) - 1; last >= 0 {
+		b := class.free[last]
+		class.free[last] = nil
+		class.free = class.free[:last]
+		atomic.AddInt64(&p.free, -int64(class.size))
+		return b[:n]
+	}
+	return make([]byte, n, class.size)
+}
+
+func (p *bufferPool) put(b []byte) {
+	class := p.class(cap(b))
+	if class == nil || class.size != cap(b) {
+		return
+	}
+	if atomic.AddInt64(&p.free, int64(class.size)) > maxFreeBytes {
+		atomic.AddInt64(&p.free, -int64(class.size))
+		return
+	}
+	class.mutex.Lock()
+	defer class.mutex.Unlock()
+	class.free = append(class.free, b[:0])
+}
+
+// drops the free buffers of classes idle for longer than idle
+func (p *bufferPool) drop(idle time.Duration) {
+	now := p.now()
+	for _, class := range p.classes {
+		class.mutex.Lock()
+		if now.Sub(class.used) > idle {
+			atomic.AddInt64(&p.free, -int64(len(class.free)*class.size))
+			class.free = nil
+		}
+		class.mutex.Unlock()
+	}
+}
+
+func (p *bufferPool) shrinkIdle(idle time.Duration) {
+	ticker := time.NewTicker(idle)
+	defer ticker.Stop()
+	for range ticker.C {
+		p.drop(idle)
+	}
+}
+
+func (p *bufferPool) count() (int, int64) {
+	count, size := 0, int64(0)
+	for _, class := range p.classes {
+		class.mutex.Lock()
+		count += len(class.free)
+		size += int64(len(class.free) * class.size)
+		class.mutex.Unlock()
+	}
+	return count, size
+}
+
+// a reader over a pooled buffer, which puts it back on Release
+type PooledReader struct {
+	*bytes.Reader
+	buffer []byte
+}
+
+func NewPooledReader(buffer []byte) *PooledReader {
+	return &PooledReader{Reader: bytes.NewReader(buffer), buffer: buffer}
+}
+
+// the reader can't be used after
+func (r *PooledReader) Release() {
+	PutBuffer(r.buffer)
+	r.buffer = nil
+	r.Reader = nil
+}
@@ -0,0 +1,124 @@
+package core_utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBufferPool_SizeClasses(t *testing.T) {
+	pool := newBufferPool()
+	for n, size := range map[int]int{0: 512, 1: 512, 512: 512, 513: 1024, 64000: 65536, maxBufferClass: maxBufferClass} {
+		b := pool.get(n)
+		assert.Equal(t, n, len(b))
+		assert.Equal(t, size, cap(b))
+	}
+}
+
+func TestBufferPool_TooBigIsNotPooled(t *testing.T) {
+	pool := newBufferPool()
+	b := pool.get(maxBufferClass + 1)
+	assert.Equal(t, maxBufferClass+1, len(b))
+	pool.put(b)
+	count, _ := pool.count()
+	assert.Equal(t, 0, count)
+}
+
+func TestBufferPool_Reuse(t *testing.T) {
+	pool := newBufferPool()
+	b := pool.get(1000)
+	b[0] = 'x'
+	pool.put(b[:10])
+	count, size := pool.count()
+	assert.Equal(t, 1, count)
+	assert.Equal(t, int64(1024), size)
+
+	again := pool.get(600)
+	assert.Equal(t, 600, len(again))
+	assert.Equal(t, byte('x'), again[0])
+	count, _ = pool.count()
+	assert.Equal(t, 0, count)
+}
+
+func TestBufferPool_ForeignBuffersAreDropped(t *testing.T) {
+	pool := newBufferPool()
+	pool.put(make([]byte, 1000))
+	pool.put(make([]byte, 100))
+	pool.put(pool.get(1000)[1:])
+	count, _ := pool.count()
+	assert.Equal(t, 0, count)
+}
+
+func TestBufferPool_FreeBytesAreCapped(t *testing.T) {
+	pool := newBufferPool()
+	var got [][]byte
+	for i := 0; i < maxFreeBytes/maxBufferClass+2; i++ {
+		got = append(got, pool.get(maxBufferClass))
+	}
+	for _, b := range got {
+		pool.put(b)
+	}
+	_, size := pool.count()
+	assert.Equal(t, int64(maxFreeBytes), size)
+}
+
+func TestBufferPool_FreeBytesAreCappedAcrossClasses(t *testing.T) {
+	pool := newBufferPool()
+	var got [][]byte
+	for size := minBufferClass; size <= maxBufferClass; size <<= 1 {
+		for i := 0; i < maxFreeBytes/maxBufferClass+2; i++ {
+			got = append(got, pool.get(size))
+		}
+	}
+	for _, b := range got {
+		pool.put(b)
+	}
+	_, size := pool.count()
+	assert.True(t, size <= maxFreeBytes, "%d bytes pooled", size)
+	assert.Equal(t, size, atomic.LoadInt64(&pool.free))
+
+	// taking them all again leaves the room for others
+	for _, b := range got {
+		pool.get(cap(b))
+	}
+	count, _ := pool.count()
+	assert.Equal(t, 0, count)
+	pool.put(pool.get(maxBufferClass))
+	_, size = pool.count()
+	assert.Equal(t, int64(maxBufferClass), size)
+}
+
+func TestBufferPool_DropIdle(t *testing.T) {
+	pool := newBufferPool()
+	now := time.Now()
+	pool.now = func() time.Time { return now }
+	pool.put(pool.get(1000))
+	pool.put(pool.get(10000))
+
+	now = now.Add(time.Minute)
+	pool.put(pool.get(1000))
+	pool.drop(30 * time.Second)
+	count, size := pool.count()
+	assert.Equal(t, 1, count)
+	assert.Equal(t, int64(1024), size)
+
+	now = now.Add(time.Minute)
+	pool.drop(30 * time.Second)
+	count, _ = pool.count()
+	assert.Equal(t, 0, count)
+	assert.Equal(t, int64(0), atomic.LoadInt64(&pool.free))
+}
+
+func TestPooledReader(t *testing.T) {
+	b := GetBuffer(3)
+	copy(b, "abc")
+	reader := NewPooledReader(b)
+	assert.Equal(t, 3, reader.Len())
+	res, err := ioutil.ReadAll(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, "abc", string(res))
+	reader.Release()
+	assert.Nil(t, reader.Reader)
+}
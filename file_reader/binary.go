@@ -0,0 +1,94 @@
+package file_reader
+
+import (
+	"io"
+	"os"
+	"unicode/utf8"
+)
+
+const sniffSize = 8192
+
+// more than this fraction of a sample being invalid utf8 or control characters means binary
+const maxNonTextRatio = 0.1
+
+// sniffs the head and tail of the file; wtmp, lastlog, journal files, etc. are binary.
+// separator is what ends a record. does not move the file's seek position
+func IsBinary(file *os.File, separator byte) (bool, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	size := info.Size()
+	head, err := readSample(file, 0, size)
+	if err != nil || looksBinary(head, separator) {
+		return err == nil, err
+	}
+	if size <= sniffSize {
+		return false, nil
+	}
+
+	tail, err := readSample(file, size-sniffSize, size)
+	if err != nil {
+		return false, err
+	}
+	return looksBinary(tail, separator), nil
+}
+
+func readSample(file io.ReaderAt, offset int64, size int64) ([]byte, error) {
+	length := size - offset
+	if length > sniffSize {
+		length = sniffSize
+	}
+	sample := make([]byte, length)
+	amt, err := file.ReadAt(sample, offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return sample[:amt], nil
+}
+
+func looksBinary(sample []byte, separator byte) bool {
+	nonText := 0
+	for i := 0; i < len(sample); {
+		c := sample[i]
+		if c == separator {
+			i++
+			continue
+		}
+		if c == 0 {
+			// a run of NULs at the start of a record is a hole left by copytruncate rotation
+			if i > 0 && sample[i-1] != separator {
+				return true
+			}
+			for i < len(sample) && sample[i] == 0 {
+				i++
+			}
+			continue
+		}
+		if c < utf8.RuneSelf {
+			if isControl(c) {
+				nonText++
+			}
+			i++
+			continue
+		}
+
+		r, size := utf8.DecodeRune(sample[i:])
+		// a multibyte character cut off at the end of the sample is fine
+		if r == utf8.RuneError && size == 1 && utf8.FullRune(sample[i:]) {
+			nonText++
+		}
+		i += size
+	}
+	return float64(nonText) > float64(len(sample))*maxNonTextRatio
+}
+
+// tabs, new lines, carriage returns, form feeds and escapes (colors) show up in text logs
+func isControl(c byte) bool {
+	switch c {
+	case '\t', '\n', '\v', '\f', '\r', 0x1b:
+		return false
+	}
+	return c < 0x20 || c == 0x7f
+}
@@ -0,0 +1,63 @@
+package file_reader
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestLooksBinary(t *testing.T) {
+	assert.False(t, looksBinary(nil, '\n'))
+	assert.False(t, looksBinary([]byte("Feb 25 10:00:00 host kernel: hello\n"), '\n'))
+	assert.False(t, looksBinary([]byte("\x1b[32mgreen\x1b[0m\r\n\ttabbed\n"), '\n'))
+	assert.False(t, looksBinary([]byte("café 日本\n"), '\n'))
+	assert.False(t, looksBinary([]byte("cut off \xe6\x97"), '\n'))
+
+	assert.True(t, looksBinary([]byte("abc\x00def\n"), '\n'))
+	assert.True(t, looksBinary([]byte("\x00\x00abc\x00def\n"), '\n'))
+	assert.True(t, looksBinary([]byte{0x07, 'r', 'o', 'o', 't', 0x01, 0x02, 0x03, 'a', 'b'}, '\n'))
+	assert.True(t, looksBinary(bytes.Repeat([]byte{0xff, 'a', 'b', 'c'}, 100), '\n'))
+
+	// record separators other than new lines
+	assert.False(t, looksBinary([]byte("abc\x00def\x00\x00\x00"), 0))
+	assert.True(t, looksBinary([]byte("abc\x00def\x00\x00\x00"), '\n'))
+	assert.False(t, looksBinary([]byte("\x00\x00abc,\x00\x00def,"), ','))
+
+	// holes
+	assert.False(t, looksBinary([]byte("\x00\x00\x00\x00abc\n"), '\n'))
+	assert.False(t, looksBinary([]byte("abc\n\x00\x00\x00\x00def\n"), '\n'))
+	assert.False(t, looksBinary([]byte("\x00\x00\x00\x00"), '\n'))
+}
+
+func TestIsBinary(t *testing.T) {
+	test := func(filename string, contents string) bool {
+		assert.False(t, DoesFileExist(filename))
+		defer os.Remove(filename)
+		assert.Nil(t, CreateAndWriteFile(filename, contents))
+
+		file, err := os.Open(filename)
+		assert.Nil(t, err)
+		defer file.Close()
+
+		binary, err := IsBinary(file, '\n')
+		assert.Nil(t, err)
+		pos, err := file.Seek(0, io.SeekCurrent)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(0), pos)
+		return binary
+	}
+
+	assert.False(t, test("test_binary", ""))
+	assert.False(t, test("test_binary", "abc\ndef\n"))
+	assert.True(t, test("test_binary", "abc\x00\x07\x00def\n"))
+
+	// only the head and tail are looked at
+	text := bytes.Repeat([]byte("some text\n"), 2000)
+	assert.True(t, test("test_binary", "a\x00"+string(text)))
+	assert.True(t, test("test_binary", string(text)+"a\x00"))
+	assert.False(t, test("test_binary", "\x00\x00\x00"+string(text)))
+	middle := string(text) + "a\x00" + string(text)
+	assert.False(t, test("test_binary", middle))
+}
@@ -0,0 +1,238 @@
+package file_reader
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// files nobody has used in this long are closed
+const FileIdleTimeout = 10 * time.Second
+
+// how long Open waits for a file to be closed, or left idle, when as many are open as the cache allows
+const FileWaitTimeout = time.Second
+
+// Open gave up waiting for a file to be closed
+var ErrTooManyFiles = errors.New("too many files open")
+
+// open files shared by concurrent reads, which read them with ReadAt (see seekReadEnd) so none of them
+// depends on the file's seek position. A file opened under a key (its path) is shared by every read of
+// that key for as long as the path is still that file (the same device and inode); a rotated file is
+// replaced, and closed once the reads still using it are done.
+// At most max files are open at once, in use or kept open while nobody uses them; the ones idle longest
+// are closed first to make room. With every one of them in use, files are opened just for the read once
+// one is closed, up to FileWaitTimeout
+type FileCache struct {
+	max  int
+	now  func() time.Time
+	wait time.Duration
+
+	mutex sync.Mutex
+	files map[string]*cachedFile
+	open  int           // files open, in files or not
+	freed chan struct{} // closed, and replaced, whenever one of them is closed or left idle
+	sweep *time.Timer   // closing idle files, while there are any
+}
+
+type cachedFile struct {
+	file   *os.File
+	info   os.FileInfo
+	refs   int
+	cached bool // in files; the last read of a file that isn't closes it
+	used   time.Time
+}
+
+// a file from FileCache.Open, to read with ReadAt until Release. It mustn't be closed or seeked
+type SharedFile struct {
+	cache    *FileCache
+	entry    *cachedFile
+	released bool
+}
+
+// max is how many files are open at most; 0 keeps none, every Open opens a file of its own with no limit
+func NewFileCache(max int) *FileCache {
+	return &FileCache{max: max, now: time.Now, wait: FileWaitTimeout, files: map[string]*cachedFile{}, freed: make(chan struct{})}
+}
+
+// the file open under key, if key's path is still that file, or else open's file
+func (c *FileCache) Open(key string, open func() (*os.File, error)) (*SharedFile, error) {
+	c.mutex.Lock()
+	if entry := c.files[key]; entry != nil {
+		c.mutex.Unlock()
+		info, err := os.Lstat(key)
+		c.mutex.Lock()
+		if err == nil && c.files[key] == entry && os.SameFile(entry.info, info) {
+			defer c.mutex.Unlock()
+			return c.acquire(entry), nil
+		}
+	}
+	if err := c.reserve(); err != nil {
+		c.mutex.Unlock()
+		return nil, err
+	}
+	c.mutex.Unlock()
+
+	file, err := open()
+	var info os.FileInfo
+	if err == nil {
+		if info, err = file.Stat(); err != nil {
+			file.Close()
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err != nil {
+		c.closed()
+		return nil, err
+	}
+	if entry := c.files[key]; entry != nil {
+		if os.SameFile(entry.info, info) {
+			c.close(file)
+			return c.acquire(entry), nil
+		}
+		// rotated
+		c.uncache(key, entry)
+	}
+
+	entry := &cachedFile{file: file, info: info}
+	c.evict(c.max - 1)
+	if len(c.files) < c.max {
+		entry.cached = true
+		c.files[key] = entry
+	}
+	return c.acquire(entry), nil
+}
+
+// how many files are kept open, in use or not, for monitoring
+func (c *FileCache) Count() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.files)
+}
+
+// counts a file about to be opened, closing idle ones or waiting for one to be closed to make room
+func (c *FileCache) reserve() error {
+	var timer *time.Timer
+	for c.max > 0 && c.open >= c.max {
+		c.evict(len(c.files) - (c.open - c.max + 1))
+		if c.open < c.max {
+			break
+		}
+		if timer == nil {
+			timer = time.NewTimer(c.wait)
+			defer timer.Stop()
+		}
+		freed := c.freed
+		c.mutex.Unlock()
+		select {
+		case <-freed:
+			c.mutex.Lock()
+		case <-timer.C:
+			c.mutex.Lock()
+			return ErrTooManyFiles
+		}
+	}
+	c.open++
+	return nil
+}
+
+func (c *FileCache) close(file *os.File) {
+	file.Close()
+	c.closed()
+}
+
+func (c *FileCache) closed() {
+	c.open--
+	c.signal()
+}
+
+// wakes up the Opens waiting for a file to be closed, or to be idle so they can close it
+func (c *FileCache) signal() {
+	close(c.freed)
+	c.freed = make(chan struct{})
+}
+
+func (c *FileCache) acquire(entry *cachedFile) *SharedFile {
+	entry.refs++
+	entry.used = c.now()
+	return &SharedFile{cache: c, entry: entry}
+}
+
+func (c *FileCache) uncache(key string, entry *cachedFile) {
+	delete(c.files, key)
+	entry.cached = false
+	if entry.refs == 0 {
+		c.close(entry.file)
+	}
+}
+
+// closes the idle files until at most keep are open, longest idle first
+func (c *FileCache) evict(keep int) {
+	if len(c.files) <= keep {
+		return
+	}
+	var idle []string
+	for key, entry := range c.files {
+		if entry.refs == 0 {
+			idle = append(idle, key)
+		}
+	}
+	sort.Slice(idle, func(i, j int) bool {
+		return c.files[idle[i]].used.Before(c.files[idle[j]].used)
+	})
+	for _, key := range idle {
+		if len(c.files) <= keep {
+			break
+		}
+		c.uncache(key, c.files[key])
+	}
+}
+
+// closes the files idle for longer than FileIdleTimeout, and comes back while there are others
+func (c *FileCache) closeIdle() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := c.now()
+	for key, entry := range c.files {
+		if entry.refs == 0 && now.Sub(entry.used) >= FileIdleTimeout {
+			c.uncache(key, entry)
+		}
+	}
+	c.sweep = nil
+	c.scheduleSweep()
+}
+
+func (c *FileCache) scheduleSweep() {
+	if c.sweep == nil && len(c.files) > 0 {
+		c.sweep = time.AfterFunc(FileIdleTimeout, c.closeIdle)
+	}
+}
+
+func (f *SharedFile) File() *os.File {
+	return f.entry.file
+}
+
+// the file can't be used after; releasing it again does nothing
+func (f *SharedFile) Release() {
+	c := f.cache
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if f.released {
+		return
+	}
+	f.released = true
+	entry := f.entry
+	entry.refs--
+	entry.used = c.now()
+	if entry.refs == 0 {
+		if !entry.cached {
+			c.close(entry.file)
+		} else {
+			c.scheduleSweep()
+			c.signal()
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package file_reader
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func newTestFileCache(max int) (*FileCache, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	cache := NewFileCache(max)
+	cache.now = clock.Now
+	cache.wait = 50 * time.Millisecond
+	return cache, clock
+}
+
+// counts the opens
+func countingOpen(filename string, opened *int) func() (*os.File, error) {
+	return func() (*os.File, error) {
+		*opened++
+		return os.Open(filename)
+	}
+}
+
+func isClosed(file *os.File) bool {
+	_, err := file.ReadAt(make([]byte, 1), 0)
+	return errors.Is(err, os.ErrClosed)
+}
+
+func writeTempFiles(t *testing.T, contents ...string) (string, []string) {
+	dir, err := ioutil.TempDir("", "file_cache")
+	assert.Nil(t, err)
+	var names []string
+	for i, content := range contents {
+		name := filepath.Join(dir, string(rune('a'+i)))
+		assert.Nil(t, ioutil.WriteFile(name, []byte(content), 0600))
+		names = append(names, name)
+	}
+	return dir, names
+}
+
+func TestFileCache_Shared(t *testing.T) {
+	dir, names := writeTempFiles(t, "abc\n")
+	defer os.RemoveAll(dir)
+	cache, _ := newTestFileCache(4)
+
+	opened := 0
+	first, err := cache.Open(names[0], countingOpen(names[0], &opened))
+	assert.Nil(t, err)
+	second, err := cache.Open(names[0], countingOpen(names[0], &opened))
+	assert.Nil(t, err)
+	third, err := cache.Open(names[0], countingOpen(names[0], &opened))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, opened)
+	assert.Same(t, first.File(), second.File())
+	assert.Same(t, first.File(), third.File())
+
+	first.Release()
+	first.Release()
+	second.Release()
+	third.Release()
+	assert.False(t, isClosed(first.File()))
+	assert.Equal(t, 1, cache.Count())
+}
+
+func TestFileCache_Rotated(t *testing.T) {
+	dir, names := writeTempFiles(t, "old\n", "new\n")
+	defer os.RemoveAll(dir)
+	cache, _ := newTestFileCache(4)
+
+	opened := 0
+	old, err := cache.Open(names[0], countingOpen(names[0], &opened))
+	assert.Nil(t, err)
+	assert.Nil(t, os.Rename(names[1], names[0]))
+
+	// right away, the path is checked every time
+	rotated, err := cache.Open(names[0], countingOpen(names[0], &opened))
+	assert.Nil(t, err)
+	assert.NotSame(t, old.File(), rotated.File())
+	contents := make([]byte, 4)
+	_, err = rotated.File().ReadAt(contents, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "new\n", string(contents))
+
+	// the old file is still read from, until it's released
+	_, err = old.File().ReadAt(contents, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "old\n", string(contents))
+	old.Release()
+	assert.True(t, isClosed(old.File()))
+	rotated.Release()
+	assert.False(t, isClosed(rotated.File()))
+}
+
+func TestFileCache_Evicted(t *testing.T) {
+	dir, names := writeTempFiles(t, "a\n", "b\n", "c\n")
+	defer os.RemoveAll(dir)
+	cache, clock := newTestFileCache(2)
+
+	opened := 0
+	var files []*SharedFile
+	for _, name := range names[:2] {
+		file, err := cache.Open(name, countingOpen(name, &opened))
+		assert.Nil(t, err)
+		files = append(files, file)
+		clock.now = clock.now.Add(time.Millisecond)
+	}
+
+	// the one idle longest goes
+	files[1].Release()
+	clock.now = clock.now.Add(time.Millisecond)
+	files[0].Release()
+	third, err := cache.Open(names[2], countingOpen(names[2], &opened))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, cache.Count())
+	assert.False(t, isClosed(files[0].File()))
+	assert.True(t, isClosed(files[1].File()))
+	third.Release()
+	assert.False(t, isClosed(third.File()))
+}
+
+func TestFileCache_None(t *testing.T) {
+	dir, names := writeTempFiles(t, "a\n")
+	defer os.RemoveAll(dir)
+	cache, _ := newTestFileCache(0)
+
+	opened := 0
+	first, err := cache.Open(names[0], countingOpen(names[0], &opened))
+	assert.Nil(t, err)
+	second, err := cache.Open(names[0], countingOpen(names[0], &opened))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, opened)
+	assert.NotSame(t, first.File(), second.File())
+	first.Release()
+	second.Release()
+	assert.True(t, isClosed(first.File()))
+	assert.True(t, isClosed(second.File()))
+}
+
+func TestFileCache_CloseIdle(t *testing.T) {
+	dir, names := writeTempFiles(t, "a\n", "b\n")
+	defer os.RemoveAll(dir)
+	cache, clock := newTestFileCache(4)
+
+	opened := 0
+	idle, err := cache.Open(names[0], countingOpen(names[0], &opened))
+	assert.Nil(t, err)
+	idle.Release()
+	used, err := cache.Open(names[1], countingOpen(names[1], &opened))
+	assert.Nil(t, err)
+
+	clock.now = clock.now.Add(FileIdleTimeout)
+	cache.closeIdle()
+	assert.True(t, isClosed(idle.File()))
+	assert.False(t, isClosed(used.File()))
+	assert.Equal(t, 1, cache.Count())
+	used.Release()
+}
+
+func TestFileCache_OpenError(t *testing.T) {
+	cache, _ := newTestFileCache(4)
+	opened := 0
+	_, err := cache.Open("missing", countingOpen("/nonexistent/missing", &opened))
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, cache.Count())
+}
+
+func TestFileCache_Budget(t *testing.T) {
+	dir, names := writeTempFiles(t, "a\n", "b\n", "c\n")
+	defer os.RemoveAll(dir)
+	cache, _ := newTestFileCache(2)
+
+	opened := 0
+	first, err := cache.Open(names[0], countingOpen(names[0], &opened))
+	assert.Nil(t, err)
+	second, err := cache.Open(names[1], countingOpen(names[1], &opened))
+	assert.Nil(t, err)
+
+	// both are in use, the third waits for one of them to be closed
+	_, err = cache.Open(names[2], countingOpen(names[2], &opened))
+	assert.Equal(t, ErrTooManyFiles, err)
+	assert.Equal(t, 2, opened)
+
+	// opened once one of them is idle, which is closed to make room
+	third := make(chan *SharedFile)
+	go func() {
+		file, err := cache.Open(names[2], countingOpen(names[2], &opened))
+		assert.Nil(t, err)
+		third <- file
+	}()
+	time.Sleep(10 * time.Millisecond)
+	first.Release()
+	file := <-third
+	assert.True(t, isClosed(first.File()))
+	assert.Equal(t, 2, cache.Count())
+	file.Release()
+	second.Release()
+}
@@ -6,6 +6,7 @@ import (
 	"log_monitor/monitor/core"
 	"log_monitor/monitor/core_utils"
 	"os"
+	"runtime"
 )
 
 const chunkSize = int64(64000)
@@ -16,11 +17,18 @@ func ReadReverseNLinesChunk(filename string, numLines uint64) (io.ReadSeeker, er
 		return nil, err
 	}
 	defer file.Close()
+	return ReadReverseNLinesChunkFile(file, numLines, chunk_reader.Options{}, nil)
+}
 
-	buffer, err := core_utils.SeekEnd(file)
-	return core_utils.LogFuncBind(buffer, err, func(b io.ReadSeeker) (io.ReadSeeker, error) {
-		return chunk_reader.ReadReverseNLines(b, numLines, chunkSize)
-	})
+// the caller owns file and is responsible for closing it; options.Chunk defaults to chunkSize
+// and options.PartialWait to chunk_reader.DefaultPartialWait
+func ReadReverseNLinesChunkFile(file *os.File, numLines uint64, options chunk_reader.Options, report *chunk_reader.Report) (io.ReadSeeker, error) {
+	options = withDefaults(options)
+	buffer, err := seekReadEnd(file, options)
+	if err != nil {
+		return nil, err
+	}
+	return chunk_reader.ReadReverseNLinesWith(buffer, numLines, options, report)
 }
 
 func ReadReversePassesFilterChunk(filename string, expr string) (io.ReadSeeker, error) {
@@ -29,11 +37,33 @@ func ReadReversePassesFilterChunk(filename string, expr string) (io.ReadSeeker,
 		return nil, err
 	}
 	defer file.Close()
+	return ReadReversePassesFilterChunkFile(file, expr, chunk_reader.Options{}, nil)
+}
 
-	buffer, err := core_utils.SeekEnd(file)
-	return core_utils.LogFuncBind(buffer, err, func(b io.ReadSeeker) (io.ReadSeeker, error) {
-		return chunk_reader.ReadReversePassesFilter(b, expr, chunkSize)
-	})
+// the caller owns file and is responsible for closing it; options.Chunk defaults to chunkSize
+// and options.PartialWait to chunk_reader.DefaultPartialWait. the whole file is read, in as many
+// ranges at once as GOMAXPROCS (see chunk_reader.ReadReversePassesFilterAt)
+func ReadReversePassesFilterChunkFile(file *os.File, expr string, options chunk_reader.Options, report *chunk_reader.Report) (io.ReadSeeker, error) {
+	options = withDefaults(options)
+	buffer, err := seekReadEnd(file, options)
+	if err != nil {
+		return nil, err
+	}
+	end, err := buffer.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	return chunk_reader.ReadReversePassesFilterAt(file, end, expr, runtime.GOMAXPROCS(0), options, report)
+}
+
+func withDefaults(options chunk_reader.Options) chunk_reader.Options {
+	if options.Chunk == 0 {
+		options.Chunk = chunkSize
+	}
+	if options.PartialWait == 0 {
+		options.PartialWait = chunk_reader.DefaultPartialWait
+	}
+	return options
 }
 
 func ReadReverseNLines(filename string, numLines uint64) (io.ReadSeeker, error) {
@@ -0,0 +1,164 @@
+package file_reader
+
+import (
+	"errors"
+	"io"
+	"log_monitor/monitor/chunk_reader"
+	"os"
+	"runtime"
+	"runtime/debug"
+)
+
+// how a file is read
+type Backend int
+
+const (
+	ChunkBackend Backend = iota // into a buffer a chunk at a time
+	MmapBackend                 // mapped read only, chunks are parsed where they are mapped; chunked where mmap isn't supported
+)
+
+func ParseBackend(backend string) (Backend, error) {
+	switch backend {
+	case "", "chunk":
+		return ChunkBackend, nil
+	case "mmap":
+		return MmapBackend, nil
+	}
+	return ChunkBackend, errors.New("unknown backend: " + backend)
+}
+
+var errMmapUnsupported = errors.New("mmap is only supported on linux")
+
+// like ReadReverseNLinesChunkFile, read with backend
+func ReadReverseNLinesFile(file *os.File, numLines uint64, backend Backend, options chunk_reader.Options, report *chunk_reader.Report) (io.ReadSeeker, error) {
+	if backend != MmapBackend {
+		return ReadReverseNLinesChunkFile(file, numLines, options, report)
+	}
+	options = withDefaults(options)
+	buffer, err := seekReadEnd(file, options)
+	if err != nil {
+		return nil, err
+	}
+	end, err := buffer.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	mapped, err := mapFile(file, end)
+	if err == errMmapUnsupported {
+		return ReadReverseNLinesChunkFile(file, numLines, options, report)
+	} else if err != nil {
+		return nil, err
+	}
+	defer mapped.unmap()
+	return chunk_reader.ReadReverseNLinesWith(mapped, numLines, options, report)
+}
+
+// like ReadReversePassesFilterChunkFile, read with backend
+func ReadReversePassesFilterFile(file *os.File, expr string, backend Backend, options chunk_reader.Options, report *chunk_reader.Report) (io.ReadSeeker, error) {
+	if backend != MmapBackend {
+		return ReadReversePassesFilterChunkFile(file, expr, options, report)
+	}
+	options = withDefaults(options)
+	buffer, err := seekReadEnd(file, options)
+	if err != nil {
+		return nil, err
+	}
+	end, err := buffer.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	mapped, err := mapFile(file, end)
+	if err == errMmapUnsupported {
+		return ReadReversePassesFilterChunkFile(file, expr, options, report)
+	} else if err != nil {
+		return nil, err
+	}
+	defer mapped.unmap()
+	return chunk_reader.ReadReversePassesFilterAt(mapped, int64(len(mapped.data)), expr, runtime.GOMAXPROCS(0), options, report)
+}
+
+// a file mapped up to where its read starts (see mapFile), or a section of one, which is a
+// chunk_reader.BytesReader. Bytes past the end of the file fault instead of reading as zeros, so the
+// file's size is checked before any are handed out; the file can still shrink after, which
+// chunk_reader and copyMapped turn into a truncation error
+type mappedFile struct {
+	file    *os.File
+	data    []byte // from base on
+	base    int64  // of data in the file
+	pos     int64  // in data
+	mapping []byte // to unmap; nil for a section, or an empty file
+}
+
+// offset and length are within data
+func (m *mappedFile) Bytes(offset int64, length int) ([]byte, error) {
+	info, err := m.file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := int64(len(m.data))
+	if info.Size()-m.base < size {
+		size = info.Size() - m.base
+	}
+	if offset >= size {
+		return nil, io.EOF
+	}
+	end := offset + int64(length)
+	if end > size {
+		end = size
+	}
+	// appending can't write into the mapping
+	return m.data[offset:end:end], nil
+}
+
+func (m *mappedFile) Read(p []byte) (int, error) {
+	n, err := m.ReadAt(p, m.pos)
+	m.pos += int64(n)
+	return n, err
+}
+
+func (m *mappedFile) ReadAt(p []byte, offset int64) (int, error) {
+	mapped, err := m.Bytes(offset, len(p))
+	if err != nil {
+		return 0, err
+	}
+	n, err := copyMapped(p, mapped)
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (m *mappedFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += m.pos
+	case io.SeekEnd:
+		offset += int64(len(m.data))
+	}
+	if offset < 0 {
+		return 0, errors.New("seek before the start of the file")
+	}
+	m.pos = offset
+	return offset, nil
+}
+
+// for chunk_reader.ReadReversePassesFilterAt, which reads the sections at the same time
+func (m *mappedFile) Section(offset int64, length int64) io.ReadSeeker {
+	return &mappedFile{file: m.file, data: m.data[offset : offset+length : offset+length], base: m.base + offset}
+}
+
+var errMappedTruncated = errors.New("truncation detected")
+
+// copies out of a mapping that the file may have shrunk underneath
+func copyMapped(dst []byte, mapped []byte) (n int, err error) {
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if r := recover(); r != nil {
+			if _, fault := r.(interface{ Addr() uintptr }); !fault {
+				panic(r)
+			}
+			err = errMappedTruncated
+		}
+	}()
+	return copy(dst, mapped), nil
+}
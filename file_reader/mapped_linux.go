@@ -0,0 +1,31 @@
+package file_reader
+
+import (
+	"os"
+	"syscall"
+)
+
+// maps file read only, from the start up to end; file's seek position isn't used
+func mapFile(file *os.File, end int64) (*mappedFile, error) {
+	mapped := &mappedFile{file: file, pos: end}
+	if end == 0 {
+		// nothing to map, which mmap refuses
+		return mapped, nil
+	}
+	var err error
+	mapped.mapping, err = syscall.Mmap(int(file.Fd()), 0, int(end), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, &os.PathError{Op: "mmap", Path: file.Name(), Err: err}
+	}
+	mapped.data = mapped.mapping
+	return mapped, nil
+}
+
+func (m *mappedFile) unmap() error {
+	if m.mapping == nil {
+		return nil
+	}
+	mapping := m.mapping
+	m.mapping, m.data = nil, nil
+	return syscall.Munmap(mapping)
+}
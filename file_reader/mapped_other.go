@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package file_reader
+
+import "os"
+
+func mapFile(file *os.File, end int64) (*mappedFile, error) {
+	return nil, errMmapUnsupported
+}
+
+func (m *mappedFile) unmap() error {
+	return nil
+}
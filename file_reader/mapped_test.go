@@ -0,0 +1,129 @@
+package file_reader
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"log_monitor/monitor/chunk_reader"
+	"log_monitor/monitor/test_utils"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestParseBackend(t *testing.T) {
+	for name, expected := range map[string]Backend{"": ChunkBackend, "chunk": ChunkBackend, "mmap": MmapBackend} {
+		backend, err := ParseBackend(name)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, backend)
+	}
+	_, err := ParseBackend("sendfile")
+	assert.NotNil(t, err)
+}
+
+func TestMmapBackend_SameAsChunked(t *testing.T) {
+	file, err := os.Open("../files/syslog_mem")
+	assert.Nil(t, err)
+	defer file.Close()
+
+	for _, options := range []chunk_reader.Options{
+		{},
+		{Chunk: 7},
+		{Chunk: 100, SkipHoles: true, MaxLength: 4},
+	} {
+		for _, n := range []uint64{1, 1000, 100000} {
+			expected, err := ReadReverseNLinesChunkFile(file, n, options, nil)
+			assert.Nil(t, err)
+			res, err := ReadReverseNLinesFile(file, n, MmapBackend, options, nil)
+			assert.Nil(t, err)
+			assert.Equal(t, test_utils.GetString(expected), test_utils.GetString(res))
+		}
+
+		expected, err := ReadReversePassesFilterChunkFile(file, "_", options, nil)
+		assert.Nil(t, err)
+		res, err := ReadReversePassesFilterFile(file, "_", MmapBackend, options, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, test_utils.GetString(expected), test_utils.GetString(res))
+	}
+}
+
+func TestMmapBackend_Holes(t *testing.T) {
+	file, err := ioutil.TempFile("", "mapped")
+	assert.Nil(t, err)
+	defer os.Remove(file.Name())
+	defer file.Close()
+	_, err = file.WriteString("abc\n\x00\x00\x00def\nghi")
+	assert.Nil(t, err)
+
+	options := chunk_reader.Options{Chunk: 3, SkipHoles: true, Partial: chunk_reader.IncludePartial}
+	var report chunk_reader.Report
+	res, err := ReadReverseNLinesFile(file, 10, MmapBackend, options, &report)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ghi\n", "def\n", "abc\n"}, test_utils.GetLines(res))
+	assert.Equal(t, []chunk_reader.Hole{{Offset: 4, Length: 3}}, report.Holes)
+	assert.Equal(t, int64(3), report.Partial)
+
+	// the holes are compacted in a copy, not in the mapping (which would fault)
+	contents, err := ioutil.ReadFile(file.Name())
+	assert.Nil(t, err)
+	assert.Equal(t, "abc\n\x00\x00\x00def\nghi", string(contents))
+}
+
+func TestMmapBackend_Empty(t *testing.T) {
+	file, err := ioutil.TempFile("", "mapped")
+	assert.Nil(t, err)
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	_, chunkedErr := ReadReverseNLinesChunkFile(file, 10, chunk_reader.Options{}, nil)
+	_, err = ReadReverseNLinesFile(file, 10, MmapBackend, chunk_reader.Options{}, nil)
+	assert.Equal(t, chunkedErr, err)
+}
+
+// hands out the mapping without checking the file is still as big
+type staleBytes struct {
+	*mappedFile
+}
+
+func (s staleBytes) Bytes(offset int64, length int) ([]byte, error) {
+	return s.data[offset : offset+int64(length)], nil
+}
+
+func TestMmapBackend_Shrunk(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("mmap is only supported on linux")
+	}
+	file, err := ioutil.TempFile("", "mapped")
+	assert.Nil(t, err)
+	defer os.Remove(file.Name())
+	defer file.Close()
+	_, err = file.WriteString(strings.Repeat("0123456789abcde\n", 10000))
+	assert.Nil(t, err)
+
+	mapped, err := mapFile(file, 10000*16)
+	assert.Nil(t, err)
+	defer mapped.unmap()
+	assert.Nil(t, file.Truncate(0))
+
+	t.Run("size checked", func(t *testing.T) {
+		mapped.Seek(0, io.SeekEnd)
+		_, err := chunk_reader.ReadReverseNLinesWith(mapped, 10, chunk_reader.Options{Chunk: 4096}, nil)
+		assert.NotNil(t, err)
+
+		_, err = mapped.ReadAt(make([]byte, 10), 0)
+		assert.Equal(t, io.EOF, err)
+	})
+
+	// past the end of the file, the mapping faults
+	t.Run("shrunk after the check", func(t *testing.T) {
+		stale := staleBytes{mapped}
+		stale.Seek(0, io.SeekEnd)
+		_, err := chunk_reader.ReadReverseNLinesWith(stale, 10, chunk_reader.Options{Chunk: 4096}, nil)
+		assert.NotNil(t, err)
+		assert.Equal(t, "truncation detected", err.Error())
+
+		_, err = copyMapped(make([]byte, 10), mapped.data)
+		assert.Equal(t, errMappedTruncated, err)
+	})
+}
@@ -0,0 +1,82 @@
+package file_reader
+
+import (
+	"bytes"
+	"io"
+	"log_monitor/monitor/chunk_reader"
+	"os"
+	"time"
+)
+
+const partialPollInterval = 20 * time.Millisecond
+
+// a reader over file positioned at the end reading backwards starts from. with WaitPartial, a file that
+// ends in a fragment is polled until the writer ends it with separator, then read up to and including that
+// separator (anything written after is left for the next request); on timeout reading starts at the end as is.
+// the reader reads with ReadAt and file's own seek position is left alone, so file can be shared (see
+// FileCache). don't chain core_utils.LogFuncBind after this, it seeks to the end again
+func seekReadEnd(file *os.File, options chunk_reader.Options) (io.ReadSeeker, error) {
+	var end int64
+	if options.Partial != chunk_reader.WaitPartial {
+		info, err := file.Stat()
+		if err != nil {
+			return nil, err
+		}
+		end = info.Size()
+	} else {
+		var err error
+		if end, err = waitForRecordEnd(file, options.Delimiter.Separator(), options.PartialWait); err != nil {
+			return nil, err
+		}
+	}
+
+	reader := io.NewSectionReader(file, 0, end)
+	_, err := reader.Seek(0, io.SeekEnd)
+	return reader, err
+}
+
+func waitForRecordEnd(file *os.File, separator byte, timeout time.Duration) (int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+	if size == 0 {
+		return 0, nil
+	}
+
+	last := make([]byte, 1)
+	if _, err := file.ReadAt(last, size-1); err != nil {
+		return 0, err
+	}
+	if last[0] == separator {
+		return size, nil
+	}
+
+	// only what is written after the fragment needs looking at
+	scanned := size
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(partialPollInterval)
+
+		info, err := file.Stat()
+		if err != nil {
+			return 0, err
+		} else if info.Size() < scanned {
+			// truncated underneath us
+			return info.Size(), nil
+		}
+		size = info.Size()
+
+		written := make([]byte, size-scanned)
+		amt, err := file.ReadAt(written, scanned)
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if i := bytes.IndexByte(written[:amt], separator); i != -1 {
+			return scanned + int64(i) + 1, nil
+		}
+		scanned += int64(amt)
+	}
+	return size, nil
+}
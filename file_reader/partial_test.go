@@ -0,0 +1,54 @@
+package file_reader
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"log_monitor/monitor/chunk_reader"
+	"log_monitor/monitor/test_utils"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWaitPartial(t *testing.T) {
+	file, err := ioutil.TempFile("", "partial")
+	assert.Nil(t, err)
+	defer os.Remove(file.Name())
+	defer file.Close()
+	writer, err := os.OpenFile(file.Name(), os.O_APPEND|os.O_WRONLY, 0600)
+	assert.Nil(t, err)
+	defer writer.Close()
+	_, err = writer.WriteString("one\ntwo\nthr")
+	assert.Nil(t, err)
+
+	t.Run("writer ends the line", func(t *testing.T) {
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			writer.WriteString("ee\nfo")
+		}()
+		var report chunk_reader.Report
+		res, err := ReadReverseNLinesChunkFile(file, 2, chunk_reader.Options{Partial: chunk_reader.WaitPartial, PartialWait: 5 * time.Second}, &report)
+		assert.Nil(t, err)
+		assert.Equal(t, "three\ntwo\n", test_utils.GetString(res))
+		assert.Equal(t, int64(0), report.Partial)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		var report chunk_reader.Report
+		start := time.Now()
+		res, err := ReadReverseNLinesChunkFile(file, 2, chunk_reader.Options{Partial: chunk_reader.WaitPartial, PartialWait: 50 * time.Millisecond}, &report)
+		assert.Nil(t, err)
+		assert.Equal(t, "fo\nthree\n", test_utils.GetString(res))
+		assert.Equal(t, int64(2), report.Partial)
+		assert.True(t, time.Since(start) >= 50*time.Millisecond)
+	})
+
+	t.Run("already ended", func(t *testing.T) {
+		writer.WriteString("ur\n")
+		start := time.Now()
+		res, err := ReadReverseNLinesChunkFile(file, 1, chunk_reader.Options{Partial: chunk_reader.WaitPartial}, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, "four\n", test_utils.GetString(res))
+		assert.True(t, time.Since(start) < chunk_reader.DefaultPartialWait)
+	})
+}
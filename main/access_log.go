@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// one line of the access log; the handlers fill in what only they know (see accessEntryOf)
+type accessEntry struct {
+	Time       string     `json:"time"`
+	Principal  string     `json:"principal"`
+	Client     string     `json:"client"`
+	Method     string     `json:"method"`
+	Path       string     `json:"path"`
+	File       string     `json:"file,omitempty"` // relative to the root it is served from
+	Query      url.Values `json:"query,omitempty"`
+	Status     int        `json:"status"`
+	Bytes      int64      `json:"bytes"`   // returned
+	Scanned    int64      `json:"scanned"` // read from disk
+	DurationMs float64    `json:"duration_ms"`
+
+	audit bool // the file is one whose reads are audited
+}
+
+type accessKey struct{}
+
+// nil when requests aren't being logged
+func accessEntryOf(r *http.Request) *accessEntry {
+	entry, _ := r.Context().Value(accessKey{}).(*accessEntry)
+	return entry
+}
+
+// appends json lines to a file
+type jsonLog struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+func openJSONLog(filename string) (*jsonLog, error) {
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonLog{file: file}, nil
+}
+
+func (l *jsonLog) write(v interface{}) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	_, err = l.file.Write(append(line, '\n'))
+	return err
+}
+
+// where requests are logged; either can be nil
+type requestLogs struct {
+	access *jsonLog
+	audit  *auditLog
+}
+
+// middleware, outside the router so that every request is logged, even those no route matches
+func (l *requestLogs) log(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		entry := &accessEntry{
+			Time:      start.UTC().Format(time.RFC3339Nano),
+			Principal: requestPrincipal(r),
+			Client:    r.RemoteAddr,
+			Method:    r.Method,
+			Path:      r.URL.EscapedPath(),
+			Query:     r.URL.Query(),
+		}
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), accessKey{}, entry)))
+		entry.Status = recorder.status
+		entry.Bytes = recorder.bytes
+		entry.DurationMs = float64(time.Since(start).Microseconds()) / 1000
+
+		if l.access != nil {
+			if err := l.access.write(entry); err != nil {
+				log.Println("access log:", err)
+			}
+		}
+		if l.audit != nil && entry.audit {
+			if err := l.audit.write(entry); err != nil {
+				log.Println("audit log:", err)
+			}
+		}
+	})
+}
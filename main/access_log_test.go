@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"log_monitor/monitor/path_guard"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// every json line in filename
+func readJSONLines(t *testing.T, filename string) []map[string]interface{} {
+	file, err := os.Open(filename)
+	assert.Nil(t, err)
+	defer file.Close()
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var line map[string]interface{}
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &line))
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestAccessLog(t *testing.T) {
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+	access, err := openJSONLog(filepath.Join(dir, "access.json"))
+	assert.Nil(t, err)
+
+	_, handler := newLogServer("", 0, 0)
+	handler.logs = &requestLogs{access: access}
+	handler.load(path_guard.NewResolver(dir, path_guard.ContainedSymlinks), defaultLimits)
+	get := func(path string) {
+		r := httptest.NewRequest("GET", path, nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	get("/v1/files/nginx/access.log?lines=2&filter=GET")
+	get("/v1/files/missing.log?lines=1")
+	get("/nowhere/at/all")
+
+	lines := readJSONLines(t, filepath.Join(dir, "access.json"))
+	assert.Equal(t, 3, len(lines))
+	first := lines[0]
+	assert.Equal(t, "", first["principal"])
+	assert.Equal(t, "192.0.2.1:1234", first["client"])
+	assert.Equal(t, "GET", first["method"])
+	assert.Equal(t, "/v1/files/nginx/access.log", first["path"])
+	assert.Equal(t, "nginx/access.log", first["file"])
+	assert.Equal(t, map[string]interface{}{"lines": []interface{}{"2"}, "filter": []interface{}{"GET"}}, first["query"])
+	assert.Equal(t, float64(http.StatusOK), first["status"])
+	assert.Equal(t, float64(len("GET /index\n")), first["bytes"])
+	assert.Equal(t, float64(len("GET /\nPOST /login\nGET /index\n")), first["scanned"])
+	assert.Contains(t, first, "duration_ms")
+	assert.Contains(t, first, "time")
+
+	assert.Equal(t, float64(http.StatusNotFound), lines[1]["status"])
+	assert.Equal(t, "missing.log", lines[1]["file"])
+	// requests no route matches are logged too
+	assert.Equal(t, float64(http.StatusNotFound), lines[2]["status"])
+	assert.NotContains(t, lines[2], "file")
+	assert.Equal(t, "/nowhere/at/all", lines[2]["path"])
+}
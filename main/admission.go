@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"log_monitor/monitor/chunk_reader"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// a read waited out the queue timeout without getting a slot
+var errQueueFull = errors.New("too many reads running")
+
+// idle clients' buckets are dropped once they would have refilled anyway, at most this often
+const bucketSweepInterval = time.Minute
+
+// decides whether a request gets served now: each client gets a token bucket of requests,
+// and only so many file reads run at once with the rest queued for a while
+type admission struct {
+	rate         float64 // tokens a second; 0 is no limit
+	burst        float64
+	queueTimeout time.Duration
+	reads        chan struct{} // a slot per read allowed at once; nil is no limit
+
+	mutex     sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newAdmission(limits queryLimits) *admission {
+	a := &admission{
+		rate:         limits.rate,
+		burst:        float64(limits.burst),
+		queueTimeout: limits.queueTimeout,
+		buckets:      make(map[string]*tokenBucket),
+		now:          time.Now,
+	}
+	if a.burst < 1 {
+		a.burst = 1
+	}
+	if limits.maxConcurrentReads > 0 {
+		a.reads = make(chan struct{}, limits.maxConcurrentReads)
+	}
+	return a
+}
+
+// clients are told apart by principal, or when anonymous by the process connecting over a unix socket
+// (uid/pid), or else by address
+func clientKey(r *http.Request) string {
+	if principal := getPrincipal(r); principal != anonymousPrincipal {
+		return "principal:" + principal
+	}
+	if peer, ok := getPeer(r); ok {
+		return "peer:" + peer
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "address:" + host
+}
+
+// takes a token from the client's bucket; if there is none, how long until there is
+func (a *admission) take(client string) (bool, time.Duration) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	now := a.now()
+	if now.Sub(a.lastSweep) > bucketSweepInterval {
+		a.sweep(now)
+	}
+
+	bucket, ok := a.buckets[client]
+	if !ok {
+		bucket = &tokenBucket{tokens: a.burst, last: now}
+		a.buckets[client] = bucket
+	}
+	bucket.tokens = math.Min(a.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*a.rate)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / a.rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+func (a *admission) sweep(now time.Time) {
+	for client, bucket := range a.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*a.rate >= a.burst {
+			delete(a.buckets, client)
+		}
+	}
+	a.lastSweep = now
+}
+
+// middleware; needs the principal (withPrincipal) to be set
+func (a *admission) limitRate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.rate > 0 {
+			if ok, retryAfter := a.take(clientKey(r)); !ok {
+				tooManyRequests(w, retryAfter)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// waits up to the queue timeout for a read slot, given back with release. Taken by the read itself, so
+// requests sharing one (see coalescer) take one slot between them; done is the read's cancel
+func (a *admission) acquireRead(done <-chan struct{}) (release func(), err error) {
+	if a.reads == nil {
+		return func() {}, nil
+	}
+
+	timer := time.NewTimer(a.queueTimeout)
+	defer timer.Stop()
+	select {
+	case a.reads <- struct{}{}:
+		return func() { <-a.reads }, nil
+	case <-timer.C:
+		return nil, errQueueFull
+	case <-done:
+		return nil, chunk_reader.ErrCanceled
+	}
+}
+
+// Retry-After is in whole seconds, rounded up
+func tooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int64(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"log_monitor/monitor/chunk_reader"
+	"log_monitor/monitor/path_guard"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Unix(1000, 0)
+	a := newAdmission(queryLimits{rate: 2, burst: 3})
+	a.now = func() time.Time { return now }
+
+	// slots are given back
+	for i := 0; i < 3; i++ {
+		ok, _ := a.take("a")
+		assert.True(t, ok)
+	}
+	ok, retryAfter := a.take("a")
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, retryAfter)
+
+	// other clients have their own bucket
+	ok, _ = a.take("b")
+	assert.True(t, ok)
+
+	now = now.Add(500 * time.Millisecond)
+	ok, _ = a.take("a")
+	assert.True(t, ok)
+	ok, _ = a.take("a")
+	assert.False(t, ok)
+
+	// refills up to the burst, and idle full buckets are dropped
+	now = now.Add(time.Hour)
+	ok, _ = a.take("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, len(a.buckets))
+	assert.Equal(t, 2.0, a.buckets["a"].tokens)
+}
+
+func TestClientKey(t *testing.T) {
+	r := httptest.NewRequest("GET", "/syslog?lines=1", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	assert.Equal(t, "address:192.0.2.1", clientKey(r))
+
+	// authenticated clients are the same client from any address
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "ops"}}}}
+	var key string
+	withPrincipal(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key = clientKey(r)
+	})).ServeHTTP(httptest.NewRecorder(), r)
+	assert.Equal(t, "principal:ops", key)
+}
+
+func TestRateLimit(t *testing.T) {
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+	router := newRouter(path_guard.NewResolver(dir, path_guard.ContainedSymlinks), queryLimits{rate: 0.001, burst: 2})
+
+	get := func(address string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/syslog?lines=1", nil)
+		r.RemoteAddr = address
+		return executeRequest(r, router)
+	}
+
+	assert.Equal(t, http.StatusOK, get("192.0.2.1:1").Code)
+	assert.Equal(t, http.StatusOK, get("192.0.2.1:2").Code)
+	response := get("192.0.2.1:3")
+	assert.Equal(t, http.StatusTooManyRequests, response.Code)
+	assert.Equal(t, "1000", response.Header().Get("Retry-After"))
+
+	assert.Equal(t, http.StatusOK, get("192.0.2.2:1").Code)
+}
+
+func TestConcurrentReads(t *testing.T) {
+	a := newAdmission(queryLimits{maxConcurrentReads: 1, queueTimeout: 50 * time.Millisecond})
+	release, err := a.acquireRead(nil)
+	assert.Nil(t, err)
+
+	// queued until the timeout
+	_, err = a.acquireRead(nil)
+	assert.Equal(t, errQueueFull, err)
+
+	// or until the read is canceled
+	canceled := make(chan struct{})
+	close(canceled)
+	_, err = a.acquireRead(canceled)
+	assert.Equal(t, chunk_reader.ErrCanceled, err)
+
+	// queued until the first read is done, then it runs
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		release()
+	}()
+	release, err = a.acquireRead(nil)
+	assert.Nil(t, err)
+	release()
+}
+
+func TestConcurrentReads_Router(t *testing.T) {
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "other"), []byte("a\n"), 0600))
+	router := newRouter(path_guard.NewResolver(dir, path_guard.ContainedSymlinks), queryLimits{maxConcurrentReads: 1, queueTimeout: time.Second})
+	// slots are given back
+	for i := 0; i < 3; i++ {
+		r := httptest.NewRequest("GET", "/other?lines=1", nil)
+		assert.Equal(t, "a\n", executeRequest(r, router).Body.String())
+	}
+}
+
+// requests sharing a read (see coalescer) take one slot between them
+func TestConcurrentReads_Shared(t *testing.T) {
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+	limits := defaultLimits
+	limits.maxConcurrentReads = 1
+	limits.queueTimeout = 5 * time.Second
+	limits.admission = newAdmission(limits)
+	limits.coalescer = newCoalescer(0, 0)
+	router := newRouter(path_guard.NewResolver(dir, path_guard.ContainedSymlinks), limits)
+
+	// the read waits for the slot, every other request waits for the read
+	release, err := limits.admission.acquireRead(nil)
+	assert.Nil(t, err)
+	const requests = 20
+	responses := make(chan *httptest.ResponseRecorder, requests)
+	for i := 0; i < requests; i++ {
+		go func() {
+			responses <- executeRequest(httptest.NewRequest("GET", "/syslog?lines=1", nil), router)
+		}()
+	}
+	waiting := 0
+	for deadline := time.Now().Add(5 * time.Second); waiting < requests && time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		limits.coalescer.mutex.Lock()
+		for _, e := range limits.coalescer.running {
+			waiting = e.waiting
+		}
+		limits.coalescer.mutex.Unlock()
+	}
+	assert.Equal(t, requests, waiting)
+	release()
+	for i := 0; i < requests; i++ {
+		response := <-responses
+		assert.Equal(t, http.StatusOK, response.Code)
+		assert.Equal(t, "def\n", response.Body.String())
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io/ioutil"
+	"log"
+	"log_monitor/monitor/file_reader"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// one line of the audit log. Each line's hash covers the line (without the hash) and so the hash of the
+// line before it: changing, dropping or reordering lines breaks the chain from there on. With a key the
+// hashes are HMACs, which can't be recomputed by someone who only has the log
+type auditEntry struct {
+	Time      string `json:"time"`
+	Principal string `json:"principal"`
+	Client    string `json:"client"`
+	File      string `json:"file"`
+	Path      string `json:"path"`
+	Status    int    `json:"status"`
+	Bytes     int64  `json:"bytes"`
+	Prev      string `json:"prev"`
+	Hash      string `json:"hash,omitempty"`
+}
+
+// rotated when it would grow past maxSize, to filename.1 (filename.2 and so on for older ones, keep of
+// them); the chain carries on into the new file. When rotating fails, the log carries on in the file it
+// is in, past maxSize, rather than going unwritten
+type auditLog struct {
+	filename string
+	key      []byte
+	maxSize  int64 // 0 is never
+	keep     int
+
+	mutex sync.Mutex
+	file  *os.File
+	size  int64
+	last  string // hash of the last entry
+}
+
+func openAuditLog(filename string, key []byte, maxSize int64, keep int) (*auditLog, error) {
+	last, err := lastAuditHash(filename)
+	if err != nil {
+		return nil, err
+	}
+	l := &auditLog{filename: filename, key: key, maxSize: maxSize, keep: keep, last: last}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// where the chain left off: the last entry of the log, or of the last rotated one if the log was
+// rotated but not written to since. "" is a new chain
+func lastAuditHash(filename string) (string, error) {
+	for _, name := range []string{filename, filename + ".1"} {
+		if info, err := os.Stat(name); os.IsNotExist(err) || (err == nil && info.Size() == 0) {
+			continue
+		}
+		res, err := file_reader.ReadReverseNLinesChunk(name, 1)
+		if err != nil {
+			return "", err
+		}
+		line, err := ioutil.ReadAll(res)
+		if err != nil {
+			return "", err
+		}
+		var entry auditEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return "", err
+		}
+		return entry.Hash, nil
+	}
+	return "", nil
+}
+
+func (l *auditLog) open() error {
+	file, err := os.OpenFile(l.filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+func (l *auditLog) write(access *accessEntry) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	line, hash, err := l.chain(auditEntry{
+		Time:      access.Time,
+		Principal: access.Principal,
+		Client:    access.Client,
+		File:      access.File,
+		Path:      access.Path,
+		Status:    access.Status,
+		Bytes:     access.Bytes,
+		Prev:      l.last,
+	})
+	if err != nil {
+		return err
+	}
+
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			log.Println("audit log: rotation failed, writing on past max_size:", err)
+		}
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		return err
+	}
+	l.last = hash
+	// what was read stays on record even if the process dies right after
+	return l.file.Sync()
+}
+
+// the entry's line, hash included, and the hash
+func (l *auditLog) chain(entry auditEntry) ([]byte, string, error) {
+	entry.Hash = ""
+	unhashed, err := json.Marshal(entry)
+	if err != nil {
+		return nil, "", err
+	}
+	var h hash.Hash
+	if l.key != nil {
+		h = hmac.New(sha256.New, l.key)
+	} else {
+		h = sha256.New()
+	}
+	h.Write(unhashed)
+	entry.Hash = hex.EncodeToString(h.Sum(nil))
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return nil, "", err
+	}
+	return append(line, '\n'), entry.Hash, nil
+}
+
+// the file stays open until the next one is, so a failure leaves the log where it was
+func (l *auditLog) rotate() error {
+	if l.keep == 0 {
+		if err := l.file.Truncate(0); err != nil {
+			return err
+		}
+		l.size = 0
+		return nil
+	}
+
+	rotated := func(i int) string {
+		return l.filename + "." + strconv.Itoa(i)
+	}
+	os.Remove(rotated(l.keep))
+	for i := l.keep - 1; i >= 1; i-- {
+		os.Rename(rotated(i), rotated(i+1))
+	}
+	if err := os.Rename(l.filename, rotated(1)); err != nil {
+		return err
+	}
+	current := l.file
+	if err := l.open(); err != nil {
+		os.Rename(rotated(1), l.filename)
+		return err
+	}
+	current.Close()
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"log_monitor/monitor/path_guard"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// checks the chain starting from prev, returning the last hash
+func verifyAuditChain(t *testing.T, filename string, key []byte, prev string) string {
+	contents, err := ioutil.ReadFile(filename)
+	assert.Nil(t, err)
+	for _, line := range strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n") {
+		var entry auditEntry
+		assert.Nil(t, json.Unmarshal([]byte(line), &entry))
+		assert.Equal(t, prev, entry.Prev)
+
+		hash := entry.Hash
+		entry.Hash = ""
+		unhashed, _ := json.Marshal(entry)
+		mac := hmac.New(sha256.New, key)
+		mac.Write(unhashed)
+		assert.Equal(t, hex.EncodeToString(mac.Sum(nil)), hash, line)
+		prev = hash
+	}
+	return prev
+}
+
+func TestAuditLog(t *testing.T) {
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+	filename := filepath.Join(dir, "audit.json")
+	key := []byte("secret")
+	audit, err := openAuditLog(filename, key, 0, 0)
+	assert.Nil(t, err)
+
+	limits := defaultLimits
+	limits.policies = []filePolicy{{Pattern: "nginx/*", Principals: principals(), Audit: true}, {Pattern: "syslog", Audit: true}}
+	_, handler := newLogServer("", 0, 0)
+	handler.logs = &requestLogs{audit: audit}
+	handler.load(path_guard.NewResolver(dir, path_guard.ContainedSymlinks), limits)
+	get := func(path string) {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	get("/v1/files/syslog?lines=1")
+	get("/v1/files/nginx/access.log?lines=1")
+	// not audited
+	get("/v1/files/installer/subiquity/server.log?lines=1")
+	get("/v1/files/nginx/")
+
+	lines := readJSONLines(t, filename)
+	assert.Equal(t, 2, len(lines))
+	assert.Equal(t, "syslog", lines[0]["file"])
+	assert.Equal(t, float64(http.StatusOK), lines[0]["status"])
+	assert.Equal(t, float64(len("def\n")), lines[0]["bytes"])
+	// attempts are on record too
+	assert.Equal(t, "nginx/access.log", lines[1]["file"])
+	assert.Equal(t, float64(http.StatusForbidden), lines[1]["status"])
+	last := verifyAuditChain(t, filename, key, "")
+
+	// carries on where it left off
+	audit, err = openAuditLog(filename, key, 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, last, audit.last)
+}
+
+func TestAuditLog_Tampered(t *testing.T) {
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+	filename := filepath.Join(dir, "audit.json")
+	audit, err := openAuditLog(filename, nil, 0, 0)
+	assert.Nil(t, err)
+	for _, file := range []string{"a", "b", "c"} {
+		assert.Nil(t, audit.write(&accessEntry{File: file, Status: http.StatusOK}))
+	}
+
+	contents, err := ioutil.ReadFile(filename)
+	assert.Nil(t, err)
+	lines := strings.SplitAfter(string(contents), "\n")
+	// who read b is rewritten, keeping the hashes
+	tampered := strings.Replace(lines[1], `"file":"b"`, `"file":"x"`, 1)
+	var entry auditEntry
+	assert.Nil(t, json.Unmarshal([]byte(tampered), &entry))
+	hash := entry.Hash
+	line, rehashed, err := audit.chain(entry)
+	assert.Nil(t, err)
+	assert.NotEqual(t, hash, rehashed)
+	// without a key the line can be rehashed, but then the next line's prev doesn't match
+	assert.Equal(t, strings.Replace(tampered, hash, rehashed, 1), string(line))
+	var next auditEntry
+	assert.Nil(t, json.Unmarshal([]byte(lines[2]), &next))
+	assert.Equal(t, hash, next.Prev)
+}
+
+func TestAuditLog_Rotation(t *testing.T) {
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+	filename := filepath.Join(dir, "audit.json")
+	key := []byte("secret")
+	audit, err := openAuditLog(filename, key, 800, 2)
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, audit.write(&accessEntry{File: "auth.log", Status: http.StatusOK}))
+	}
+
+	// three lines fit a file, the oldest are gone
+	_, err = ioutil.ReadFile(filename + ".3")
+	assert.NotNil(t, err)
+	for name, lines := range map[string]int{filename: 1, filename + ".1": 3, filename + ".2": 3} {
+		contents, err := ioutil.ReadFile(name)
+		assert.Nil(t, err)
+		assert.True(t, len(contents) <= 800)
+		assert.Equal(t, lines, strings.Count(string(contents), "\n"), name)
+	}
+
+	// the chain runs across files
+	var oldest auditEntry
+	contents, _ := ioutil.ReadFile(filename + ".2")
+	assert.Nil(t, json.Unmarshal([]byte(strings.SplitN(string(contents), "\n", 2)[0]), &oldest))
+	prev := verifyAuditChain(t, filename+".2", key, oldest.Prev)
+	prev = verifyAuditChain(t, filename+".1", key, prev)
+	assert.Equal(t, audit.last, verifyAuditChain(t, filename, key, prev))
+
+	// rotated, but nothing written since
+	assert.Nil(t, ioutil.WriteFile(filename, nil, 0600))
+	audit, err = openAuditLog(filename, key, 800, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, prev, audit.last)
+}
+
+// entries go on in the current file when it can't be rotated
+func TestAuditLog_RotationFailed(t *testing.T) {
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+	filename := filepath.Join(dir, "audit.json")
+	key := []byte("secret")
+	audit, err := openAuditLog(filename, key, 800, 1)
+	assert.Nil(t, err)
+
+	// in the way of the rename
+	assert.Nil(t, os.MkdirAll(filepath.Join(filename+".1", "taken"), 0700))
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, audit.write(&accessEntry{File: "auth.log", Status: http.StatusOK}))
+	}
+	contents, err := ioutil.ReadFile(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, strings.Count(string(contents), "\n"))
+	assert.Equal(t, audit.last, verifyAuditChain(t, filename, key, ""))
+
+	assert.Nil(t, os.RemoveAll(filename+".1"))
+	assert.Nil(t, audit.write(&accessEntry{File: "auth.log", Status: http.StatusOK}))
+	prev := verifyAuditChain(t, filename+".1", key, "")
+	assert.Equal(t, audit.last, verifyAuditChain(t, filename, key, prev))
+}
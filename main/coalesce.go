@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"log_monitor/monitor/chunk_reader"
+	"sync"
+	"time"
+)
+
+var errLeft = errors.New("the request went away before its query was done")
+
+// identical queries on the same file running at the same time share one run, e.g. many clients tailing
+// the same log; with ttl, its result is kept for that long after for the queries that come later, up to
+// maxBytes of results in all (the oldest go first). The readers put a query's whole result together
+// before any of it is written, so what is shared is the result, not a stream of it
+type coalescer struct {
+	ttl      time.Duration // 0 keeps no results
+	maxBytes int64
+	now      func() time.Time
+
+	mutex     sync.Mutex
+	running   map[queryKey]*execution
+	finished  map[string]*execution // by query, each of them dropped once stale or once its file has changed
+	kept      []*execution          // finished, oldest first; some may have been dropped already
+	keptBytes int64
+	lastSweep time.Time
+}
+
+// what a query's result depends on; a file that is written to, or rotated, is another key
+type queryKey struct {
+	query string // root, path, route and parameters
+	file  fileID
+	size  int64
+}
+
+type execution struct {
+	key      queryKey
+	done     chan struct{} // closed once result, report and err are set
+	result   []byte        // shared by every request, read only
+	report   chunk_reader.Report
+	err      error
+	finished time.Time
+
+	waiting int           // requests waiting on the result
+	cancel  chan struct{} // closed once none are
+}
+
+type queryRun func(cancel <-chan struct{}) ([]byte, chunk_reader.Report, error)
+
+func newCoalescer(ttl time.Duration, maxBytes int64) *coalescer {
+	return &coalescer{ttl: ttl, maxBytes: maxBytes, now: time.Now, running: map[queryKey]*execution{}, finished: map[string]*execution{}}
+}
+
+// the result of run, or of an identical query's already running or finished within ttl; led is whether
+// this call ran it. run is canceled once every request waiting on it is done (their done is closed),
+// and those get errLeft. without a key the query isn't shared, run is called with done as is
+func (c *coalescer) do(key queryKey, hasKey bool, done <-chan struct{}, run queryRun) (result []byte, report chunk_reader.Report, led bool, err error) {
+	if !hasKey {
+		result, report, err = run(done)
+		return result, report, true, err
+	}
+
+	c.mutex.Lock()
+	now := c.now()
+	c.sweep(now)
+	if e := c.finished[key.query]; e != nil {
+		if e.key == key && now.Sub(e.finished) < c.ttl {
+			c.mutex.Unlock()
+			return e.result, e.report, false, e.err
+		}
+		c.drop(e)
+	}
+	e := c.running[key]
+	if e == nil {
+		e = &execution{key: key, done: make(chan struct{}), cancel: make(chan struct{})}
+		c.running[key] = e
+		led = true
+		go c.run(e, run)
+	}
+	e.waiting++
+	c.mutex.Unlock()
+
+	select {
+	case <-e.done:
+		return e.result, e.report, led, e.err
+	case <-done:
+		c.leave(e)
+		return nil, chunk_reader.Report{}, led, errLeft
+	}
+}
+
+func (c *coalescer) run(e *execution, run queryRun) {
+	result, report, err := run(e.cancel)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	e.result, e.report, e.err = result, report, err
+	e.finished = c.now()
+	close(e.done)
+	if c.running[e.key] == e {
+		delete(c.running, e.key)
+	}
+	if err == nil && c.ttl > 0 {
+		c.keep(e)
+	}
+}
+
+// evicting the oldest results to make room, if e fits at all
+func (c *coalescer) keep(e *execution) {
+	size := int64(len(e.result))
+	if size > c.maxBytes {
+		return
+	}
+	for len(c.kept) > 0 && c.keptBytes+size > c.maxBytes {
+		oldest := c.kept[0]
+		c.kept[0] = nil
+		c.kept = c.kept[1:]
+		c.drop(oldest)
+	}
+	if previous := c.finished[e.key.query]; previous != nil {
+		c.drop(previous)
+	}
+	c.finished[e.key.query] = e
+	c.kept = append(c.kept, e)
+	c.keptBytes += size
+}
+
+// e may have been dropped already
+func (c *coalescer) drop(e *execution) {
+	if c.finished[e.key.query] == e {
+		delete(c.finished, e.key.query)
+		c.keptBytes -= int64(len(e.result))
+	}
+}
+
+func (c *coalescer) leave(e *execution) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	e.waiting--
+	if e.waiting == 0 && c.running[e.key] == e {
+		// anyone coming after starts over
+		delete(c.running, e.key)
+		close(e.cancel)
+	}
+}
+
+// drops the stale results, at most every ttl
+func (c *coalescer) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < c.ttl {
+		return
+	}
+	c.lastSweep = now
+	kept := c.kept[:0]
+	for _, e := range c.kept {
+		if now.Sub(e.finished) >= c.ttl {
+			c.drop(e)
+		} else if c.finished[e.key.query] == e {
+			kept = append(kept, e)
+		}
+	}
+	for i := len(kept); i < len(c.kept); i++ {
+		c.kept[i] = nil
+	}
+	c.kept = kept
+}
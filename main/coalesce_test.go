@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"log_monitor/monitor/chunk_reader"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waits for n requests to be waiting on key's run
+func waitForWaiting(c *coalescer, key queryKey, n int) {
+	for {
+		c.mutex.Lock()
+		e := c.running[key]
+		waiting := e != nil && e.waiting == n
+		c.mutex.Unlock()
+		if waiting {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCoalescer_Shared(t *testing.T) {
+	c := newCoalescer(0, 0)
+	key := queryKey{query: "lines=10", size: 100}
+	release := make(chan struct{})
+	runs := 0
+	run := func(<-chan struct{}) ([]byte, chunk_reader.Report, error) {
+		runs++
+		<-release
+		return []byte("abc\n"), chunk_reader.Report{BytesRead: 4}, nil
+	}
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	led := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, report, ran, err := c.do(key, true, nil, run)
+			assert.Nil(t, err)
+			assert.Equal(t, "abc\n", string(result))
+			assert.Equal(t, int64(4), report.BytesRead)
+			mutex.Lock()
+			defer mutex.Unlock()
+			if ran {
+				led++
+			}
+		}()
+	}
+	waitForWaiting(c, key, 10)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, 1, runs)
+	assert.Equal(t, 1, led)
+
+	// done, the next one runs again
+	release = make(chan struct{})
+	close(release)
+	_, _, ran, err := c.do(key, true, nil, run)
+	assert.Nil(t, err)
+	assert.True(t, ran)
+	assert.Equal(t, 2, runs)
+}
+
+func TestCoalescer_Canceled(t *testing.T) {
+	c := newCoalescer(0, 0)
+	key := queryKey{query: "filter=a"}
+	canceled := make(chan struct{})
+	run := func(cancel <-chan struct{}) ([]byte, chunk_reader.Report, error) {
+		<-cancel
+		close(canceled)
+		return nil, chunk_reader.Report{}, errors.New("canceled")
+	}
+
+	first, second := make(chan struct{}), make(chan struct{})
+	errs := make(chan error, 2)
+	for _, done := range []chan struct{}{first, second} {
+		go func(done chan struct{}) {
+			_, _, _, err := c.do(key, true, done, run)
+			errs <- err
+		}(done)
+	}
+	waitForWaiting(c, key, 2)
+
+	// the other one is still waiting
+	close(first)
+	assert.Equal(t, errLeft, <-errs)
+	select {
+	case <-canceled:
+		t.Fatal("canceled with a request still waiting")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(second)
+	assert.Equal(t, errLeft, <-errs)
+	<-canceled
+}
+
+func TestCoalescer_TTL(t *testing.T) {
+	c := newCoalescer(time.Second, 1<<20)
+	now := time.Unix(1000, 0)
+	c.now = func() time.Time { return now }
+	runs := 0
+	run := func(<-chan struct{}) ([]byte, chunk_reader.Report, error) {
+		runs++
+		return []byte("abc\n"), chunk_reader.Report{}, nil
+	}
+
+	key := queryKey{query: "lines=1", file: fileID{}, size: 4}
+	for i := 0; i < 3; i++ {
+		result, _, _, err := c.do(key, true, nil, run)
+		assert.Nil(t, err)
+		assert.Equal(t, "abc\n", string(result))
+	}
+	assert.Equal(t, 1, runs)
+
+	// written to
+	key.size = 8
+	c.do(key, true, nil, run)
+	assert.Equal(t, 2, runs)
+	c.do(key, true, nil, run)
+	assert.Equal(t, 2, runs)
+
+	// stale
+	now = now.Add(time.Second)
+	c.do(key, true, nil, run)
+	assert.Equal(t, 3, runs)
+	c.mutex.Lock()
+	assert.Equal(t, 1, len(c.finished))
+	c.mutex.Unlock()
+
+	// errors aren't kept
+	failing := func(<-chan struct{}) ([]byte, chunk_reader.Report, error) {
+		runs++
+		return nil, chunk_reader.Report{}, errors.New("failed")
+	}
+	other := queryKey{query: "lines=2"}
+	c.do(other, true, nil, failing)
+	c.do(other, true, nil, failing)
+	assert.Equal(t, 5, runs)
+}
+
+func TestCoalescer_NoKey(t *testing.T) {
+	c := newCoalescer(time.Second, 1<<20)
+	done := make(chan struct{})
+	runs := 0
+	for i := 0; i < 2; i++ {
+		_, _, led, err := c.do(queryKey{}, false, done, func(cancel <-chan struct{}) ([]byte, chunk_reader.Report, error) {
+			runs++
+			assert.Equal(t, (<-chan struct{})(done), cancel)
+			return nil, chunk_reader.Report{}, nil
+		})
+		assert.Nil(t, err)
+		assert.True(t, led)
+	}
+	assert.Equal(t, 2, runs)
+}
+
+func TestCoalescer_Budget(t *testing.T) {
+	c := newCoalescer(time.Second, 8)
+	now := time.Unix(1000, 0)
+	c.now = func() time.Time { return now }
+	runs := map[string]int{}
+	result := func(contents string) queryRun {
+		return func(<-chan struct{}) ([]byte, chunk_reader.Report, error) {
+			runs[contents]++
+			return []byte(contents), chunk_reader.Report{}, nil
+		}
+	}
+	kept := func() []string {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		var queries []string
+		for query := range c.finished {
+			queries = append(queries, query)
+		}
+		return queries
+	}
+
+	c.do(queryKey{query: "a"}, true, nil, result("aaa\n"))
+	now = now.Add(time.Millisecond)
+	c.do(queryKey{query: "b"}, true, nil, result("bbb\n"))
+	assert.ElementsMatch(t, []string{"a", "b"}, kept())
+
+	// the oldest makes room
+	now = now.Add(time.Millisecond)
+	c.do(queryKey{query: "c"}, true, nil, result("cc\n"))
+	assert.ElementsMatch(t, []string{"b", "c"}, kept())
+	c.do(queryKey{query: "a"}, true, nil, result("aaa\n"))
+	assert.Equal(t, 2, runs["aaa\n"])
+	assert.ElementsMatch(t, []string{"c", "a"}, kept())
+
+	// too big to keep at all
+	c.do(queryKey{query: "d"}, true, nil, result("ddddddddd\n"))
+	c.do(queryKey{query: "d"}, true, nil, result("ddddddddd\n"))
+	assert.Equal(t, 2, runs["ddddddddd\n"])
+	assert.ElementsMatch(t, []string{"c", "a"}, kept())
+	c.mutex.Lock()
+	assert.Equal(t, int64(7), c.keptBytes)
+	c.mutex.Unlock()
+
+	// stale ones give their bytes back
+	now = now.Add(time.Second)
+	c.do(queryKey{query: "e"}, true, nil, result("e\n"))
+	assert.ElementsMatch(t, []string{"e"}, kept())
+	c.mutex.Lock()
+	assert.Equal(t, int64(2), c.keptBytes)
+	assert.Equal(t, 1, len(c.kept))
+	c.mutex.Unlock()
+}
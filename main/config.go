@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log_monitor/monitor/chunk_reader"
+	"log_monitor/monitor/core"
+	"log_monitor/monitor/file_reader"
+	"log_monitor/monitor/path_guard"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// everything the server is run with; flags give the defaults and a -config file overrides whatever it sets
+type config struct {
+	Listeners    []string     `json:"listeners"`
+	SocketMode   string       `json:"socket_mode"` // octal permissions of unix sockets listened on
+	Dir          string       `json:"dir"`
+	Symlinks     string       `json:"symlinks"`
+	ReadTimeout  uint         `json:"read_timeout_ms"`
+	WriteTimeout uint         `json:"write_timeout_ms"`
+	DrainTimeout uint         `json:"drain_timeout_ms"`
+	ChunkSize    int64        `json:"chunk_size"`
+	Backend      string       `json:"backend"` // chunk or mmap
+	Limits       limitsConfig `json:"limits"`
+	TLS          tlsFiles     `json:"tls"`
+	Auth         authConfig   `json:"auth"`
+	Files        []filePolicy `json:"files"`
+	Roots        []rootConfig `json:"roots"`
+	AccessLog    string       `json:"access_log"`
+	AuditLog     auditConfig  `json:"audit_log"`
+}
+
+type auditConfig struct {
+	File    string `json:"file"`
+	KeyFile string `json:"key_file"`
+	MaxSize int64  `json:"max_size"`
+	Keep    int    `json:"keep"`
+}
+
+// a root served under /v1/roots/{name}; dir, symlinks, chunk_size and max_line_length default to the
+// top level ones, files (the top level ones are for dir) and the query parameter defaults don't
+type rootConfig struct {
+	Name          string       `json:"name"`
+	Dir           string       `json:"dir"`
+	Symlinks      string       `json:"symlinks"`
+	ChunkSize     int64        `json:"chunk_size"`
+	MaxLineLength *int         `json:"max_line_length"`
+	Delimiter     string       `json:"delimiter"`
+	RecordStart   string       `json:"record_start"`
+	Partial       string       `json:"partial"`
+	Files         []filePolicy `json:"files"`
+}
+
+type limitsConfig struct {
+	MaxLineLength      int     `json:"max_line_length"`
+	Rate               float64 `json:"rate"`
+	Burst              int     `json:"burst"`
+	MaxConcurrentReads int     `json:"max_concurrent_reads"`
+	QueueTimeout       uint    `json:"queue_timeout_ms"`
+	ReadRate           int64   `json:"read_rate"`
+	GlobalReadRate     int64   `json:"global_read_rate"`
+	CachedFiles        int     `json:"cached_files"`
+	ResultCache        uint    `json:"result_cache_ms"`
+	ResultCacheSize    int64   `json:"result_cache_size"`
+}
+
+type tlsFiles struct {
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+}
+
+type authConfig struct {
+	ClientCA string `json:"client_ca"`
+}
+
+// base overlaid with the file at filename, validated; base is left as is
+func loadConfig(filename string, base config) (config, error) {
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return config{}, err
+	}
+	// decoding into a slice decodes into the elements already there, so the file's would be merged with base's
+	loaded := base
+	loaded.Listeners, loaded.Files, loaded.Roots = nil, nil, nil
+	decoder := json.NewDecoder(bytes.NewReader(contents))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&loaded); err != nil {
+		return config{}, fmt.Errorf("%s: %v", filename, err)
+	}
+	if loaded.Listeners == nil {
+		loaded.Listeners = base.Listeners
+	}
+	if loaded.Files == nil {
+		loaded.Files = base.Files
+	}
+	if loaded.Roots == nil {
+		loaded.Roots = base.Roots
+	}
+	if err := loaded.validate(); err != nil {
+		return config{}, fmt.Errorf("%s: %v", filename, err)
+	}
+	return loaded, nil
+}
+
+func (c config) validate() error {
+	if err := c.validateListeners(); err != nil {
+		return fmt.Errorf("listeners: %v", err)
+	}
+	if _, err := strconv.ParseUint(c.SocketMode, 8, 9); err != nil {
+		return fmt.Errorf("socket_mode: %q isn't octal permissions", c.SocketMode)
+	}
+	if info, err := os.Stat(c.Dir); err != nil {
+		return fmt.Errorf("dir: %v", err)
+	} else if !info.IsDir() {
+		return fmt.Errorf("dir: %s is not a directory", c.Dir)
+	}
+	if _, err := path_guard.ParseSymlinkPolicy(c.Symlinks); err != nil {
+		return fmt.Errorf("symlinks: %v", err)
+	}
+	if c.ReadTimeout == 0 || c.WriteTimeout == 0 || c.DrainTimeout == 0 {
+		return errors.New("read_timeout_ms, write_timeout_ms and drain_timeout_ms have to be above 0")
+	}
+	if c.ChunkSize < 0 {
+		return errors.New("chunk_size can't be negative")
+	}
+	if _, err := file_reader.ParseBackend(c.Backend); err != nil {
+		return fmt.Errorf("backend: %v", err)
+	}
+	limits := c.Limits
+	if limits.MaxLineLength < 0 || limits.Rate < 0 || limits.Burst < 0 || limits.MaxConcurrentReads < 0 || limits.ReadRate < 0 || limits.GlobalReadRate < 0 || limits.CachedFiles < 0 || limits.ResultCacheSize < 0 {
+		return errors.New("limits can't be negative")
+	}
+	if (c.TLS.Cert == "") != (c.TLS.Key == "") {
+		return errors.New("tls: cert and key go together")
+	}
+	if c.Auth.ClientCA != "" && c.TLS.Cert == "" {
+		return errors.New("auth: client_ca requires tls")
+	}
+	if err := validatePolicies(c.Files); err != nil {
+		return fmt.Errorf("files%v", err)
+	}
+	if c.AuditLog.MaxSize < 0 || c.AuditLog.Keep < 0 {
+		return errors.New("audit_log: max_size and keep can't be negative")
+	} else if c.AuditLog.KeyFile != "" && c.AuditLog.File == "" {
+		return errors.New("audit_log: key_file without a file")
+	}
+
+	names := make(map[string]bool)
+	// a directory served twice with different policies could be read past them through the other one
+	served := map[string][]filePolicy{filepath.Clean(c.Dir): c.Files}
+	for i, root := range c.Roots {
+		if err := c.validateRoot(root); err != nil {
+			return fmt.Errorf("roots[%d]: %v", i, err)
+		} else if names[root.Name] {
+			return fmt.Errorf("roots[%d]: %s is already a root", i, root.Name)
+		}
+		names[root.Name] = true
+		root = c.rootDefaults(root)
+		dir := filepath.Clean(root.Dir)
+		if policies, ok := served[dir]; ok && !samePolicies(policies, root.Files) {
+			return fmt.Errorf("roots[%d]: %s is already served with other files", i, root.Dir)
+		}
+		served[dir] = root.Files
+	}
+	return nil
+}
+
+func samePolicies(a []filePolicy, b []filePolicy) bool {
+	return len(a) == 0 && len(b) == 0 || reflect.DeepEqual(a, b)
+}
+
+func (c config) validateListeners() error {
+	if len(c.Listeners) == 0 {
+		return errors.New("at least one is needed")
+	}
+	seen := make(map[string]bool)
+	for _, address := range c.Listeners {
+		if !validListener(address) {
+			return fmt.Errorf("%q isn't an address", address)
+		} else if seen[address] {
+			return fmt.Errorf("%s is listed twice", address)
+		}
+		seen[address] = true
+	}
+	for address := range seen {
+		if seen[systemdPrefix] && strings.HasPrefix(address, systemdPrefix+":") {
+			return fmt.Errorf("%s is already among the sockets %s listens on", address, systemdPrefix)
+		}
+	}
+	return nil
+}
+
+func (c config) socketMode() os.FileMode {
+	// validated already
+	mode, _ := strconv.ParseUint(c.SocketMode, 8, 9)
+	return os.FileMode(mode)
+}
+
+func validatePolicies(policies []filePolicy) error {
+	for i, policy := range policies {
+		if _, err := path.Match(policy.Pattern, ""); err != nil || policy.Pattern == "" {
+			return fmt.Errorf("[%d]: bad pattern %q", i, policy.Pattern)
+		}
+	}
+	return nil
+}
+
+var rootName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+func (c config) validateRoot(root rootConfig) error {
+	if !rootName.MatchString(root.Name) || root.Name == "." || root.Name == ".." {
+		return fmt.Errorf("name: %q has to be letters, digits, '_', '-' and '.'", root.Name)
+	}
+	root = c.rootDefaults(root)
+	if info, err := os.Stat(root.Dir); err != nil {
+		return fmt.Errorf("dir: %v", err)
+	} else if !info.IsDir() {
+		return fmt.Errorf("dir: %s is not a directory", root.Dir)
+	}
+	if _, err := path_guard.ParseSymlinkPolicy(root.Symlinks); err != nil {
+		return fmt.Errorf("symlinks: %v", err)
+	}
+	if root.ChunkSize < 0 || *root.MaxLineLength < 0 {
+		return errors.New("chunk_size and max_line_length can't be negative")
+	}
+	if _, err := core.ParseDelimiter(root.Delimiter); err != nil {
+		return fmt.Errorf("delimiter: %v", err)
+	}
+	if _, err := regexp.Compile(root.RecordStart); err != nil {
+		return fmt.Errorf("record_start: %v", err)
+	}
+	if _, err := chunk_reader.ParsePartial(root.Partial); err != nil {
+		return fmt.Errorf("partial: %v", err)
+	}
+	if err := validatePolicies(root.Files); err != nil {
+		return fmt.Errorf("files%v", err)
+	}
+	return nil
+}
+
+// root with what it leaves out taken from the top level
+func (c config) rootDefaults(root rootConfig) rootConfig {
+	if root.Dir == "" {
+		root.Dir = c.Dir
+		if root.Files == nil {
+			root.Files = c.Files
+		}
+	}
+	if root.Symlinks == "" {
+		root.Symlinks = c.Symlinks
+	}
+	if root.ChunkSize == 0 {
+		root.ChunkSize = c.ChunkSize
+	}
+	if root.MaxLineLength == nil {
+		root.MaxLineLength = &c.Limits.MaxLineLength
+	}
+	return root
+}
+
+func (c config) namedRoots() []namedRoot {
+	roots := make([]namedRoot, 0, len(c.Roots))
+	for _, root := range c.Roots {
+		root = c.rootDefaults(root)
+		// validated already
+		symlinks, _ := path_guard.ParseSymlinkPolicy(root.Symlinks)
+		roots = append(roots, namedRoot{
+			name:          root.Name,
+			resolver:      path_guard.NewResolver(root.Dir, symlinks),
+			chunkSize:     root.ChunkSize,
+			maxLineLength: *root.MaxLineLength,
+			policies:      root.Files,
+			defaults:      queryDefaults{delimiter: root.Delimiter, recordStart: root.RecordStart, partial: root.Partial},
+		})
+	}
+	return roots
+}
+
+func (c config) resolver() path_guard.Resolver {
+	// validated already
+	symlinks, _ := path_guard.ParseSymlinkPolicy(c.Symlinks)
+	return path_guard.NewResolver(c.Dir, symlinks)
+}
+
+func (c config) queryLimits() queryLimits {
+	// validated already
+	backend, _ := file_reader.ParseBackend(c.Backend)
+	return queryLimits{
+		chunkSize:          c.ChunkSize,
+		backend:            backend,
+		maxLineLength:      c.Limits.MaxLineLength,
+		rate:               c.Limits.Rate,
+		burst:              c.Limits.Burst,
+		maxConcurrentReads: c.Limits.MaxConcurrentReads,
+		queueTimeout:       time.Duration(c.Limits.QueueTimeout) * time.Millisecond,
+		readRate:           c.Limits.ReadRate,
+		globalReadRate:     c.Limits.GlobalReadRate,
+		cachedFiles:        c.Limits.CachedFiles,
+		resultTTL:          time.Duration(c.Limits.ResultCache) * time.Millisecond,
+		resultCacheSize:    c.Limits.ResultCacheSize,
+		policies:           c.Files,
+	}
+}
+
+func (c config) useTLS() bool {
+	return c.TLS.Cert != ""
+}
+
+// nil if neither log is set
+func (c config) openLogs() (*requestLogs, error) {
+	var logs requestLogs
+	var err error
+	if c.AccessLog != "" {
+		if logs.access, err = openJSONLog(c.AccessLog); err != nil {
+			return nil, err
+		}
+	}
+	if c.AuditLog.File != "" {
+		var key []byte
+		if c.AuditLog.KeyFile != "" {
+			if key, err = ioutil.ReadFile(c.AuditLog.KeyFile); err != nil {
+				return nil, err
+			}
+		}
+		if logs.audit, err = openAuditLog(c.AuditLog.File, key, c.AuditLog.MaxSize, c.AuditLog.Keep); err != nil {
+			return nil, err
+		}
+	}
+	if logs.access == nil && logs.audit == nil {
+		return nil, nil
+	}
+	return &logs, nil
+}
+
+// what changed from c to next that only takes effect on restart, empty if nothing did
+func (c config) needsRestart(next config) []string {
+	var changed []string
+	if fmt.Sprint(c.Listeners) != fmt.Sprint(next.Listeners) || c.SocketMode != next.SocketMode {
+		changed = append(changed, "listeners")
+	}
+	if c.ReadTimeout != next.ReadTimeout || c.WriteTimeout != next.WriteTimeout || c.DrainTimeout != next.DrainTimeout {
+		changed = append(changed, "timeouts")
+	}
+	if c.TLS != next.TLS || c.Auth != next.Auth {
+		changed = append(changed, "tls files")
+	}
+	if c.AccessLog != next.AccessLog || c.AuditLog != next.AuditLog {
+		changed = append(changed, "logs")
+	}
+	return changed
+}
+
+// calls changed whenever filename's size or modification time changes, checking every interval until stop is closed
+func watchConfig(filename string, interval time.Duration, changed func(), stop <-chan struct{}) {
+	stat := func() (int64, time.Time) {
+		info, err := os.Stat(filename)
+		if err != nil {
+			// gone for now, perhaps being replaced; comes back as a change
+			return -1, time.Time{}
+		}
+		return info.Size(), info.ModTime()
+	}
+
+	size, modified := stat()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			nextSize, nextModified := stat()
+			if nextSize != size || !nextModified.Equal(modified) {
+				size, modified = nextSize, nextModified
+				if nextSize >= 0 {
+					changed()
+				}
+			}
+		}
+	}
+}
+
+// -root name=dir, any number of times
+type rootFlags []rootConfig
+
+func (f *rootFlags) String() string {
+	roots := make([]string, 0, len(*f))
+	for _, root := range *f {
+		roots = append(roots, root.Name+"="+root.Dir)
+	}
+	return strings.Join(roots, ",")
+}
+
+func (f *rootFlags) Set(value string) error {
+	i := strings.Index(value, "=")
+	if i < 0 {
+		return errors.New("expected name=dir")
+	}
+	*f = append(*f, rootConfig{Name: value[:i], Dir: value[i+1:]})
+	return nil
+}
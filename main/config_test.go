@@ -0,0 +1,169 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"log_monitor/monitor/file_reader"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testConfig(dir string) config {
+	return config{
+		Listeners:    []string{"localhost:8080"},
+		SocketMode:   "0660",
+		Dir:          dir,
+		Symlinks:     "contained",
+		ReadTimeout:  100,
+		WriteTimeout: 2000,
+		DrainTimeout: 5000,
+		Limits:       limitsConfig{MaxLineLength: 1 << 20, Rate: 20, Burst: 40},
+	}
+}
+
+func writeConfig(t *testing.T, dir string, contents string) string {
+	filename := filepath.Join(dir, "config.json")
+	assert.Nil(t, ioutil.WriteFile(filename, []byte(contents), 0600))
+	return filename
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+	base := testConfig(dir)
+
+	filename := writeConfig(t, dir, `{
+		"listeners": ["127.0.0.1:9000", "[::1]:9000"],
+		"chunk_size": 4096,
+		"backend": "mmap",
+		"limits": {"rate": 5, "cached_files": 8, "result_cache_ms": 200},
+		"files": [{"pattern": "nginx/*", "principals": ["ops"]}]
+	}`)
+	loaded, err := loadConfig(filename, base)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"127.0.0.1:9000", "[::1]:9000"}, loaded.Listeners)
+	assert.Equal(t, int64(4096), loaded.ChunkSize)
+	// what the file doesn't set stays
+	assert.Equal(t, 5.0, loaded.Limits.Rate)
+	assert.Equal(t, 40, loaded.Limits.Burst)
+	assert.Equal(t, dir, loaded.Dir)
+	assert.Equal(t, []filePolicy{{Pattern: "nginx/*", Principals: principals("ops")}}, loaded.Files)
+	assert.Equal(t, []string{"listeners"}, base.needsRestart(loaded))
+
+	limits := loaded.queryLimits()
+	assert.Equal(t, int64(4096), limits.chunkSize)
+	assert.Equal(t, file_reader.MmapBackend, limits.backend)
+	assert.Equal(t, 8, limits.cachedFiles)
+	assert.Equal(t, 200*time.Millisecond, limits.resultTTL)
+	assert.Equal(t, loaded.Files, limits.policies)
+
+	// base is untouched
+	assert.Equal(t, testConfig(dir), base)
+}
+
+func TestLoadConfig_Invalid(t *testing.T) {
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+	base := testConfig(dir)
+
+	for contents, message := range map[string]string{
+		`{"listen": ["localhost:80"]}`:                      `unknown field "listen"`,
+		`{"listeners": ["unix:"]}`:                          "listeners",
+		`{"listeners": ["systemd", "systemd:web"]}`:         "listeners",
+		`{"listeners": ["localhost:80", "localhost:80"]}`:   "listed twice",
+		`{"socket_mode": "0990"}`:                           "socket_mode",
+		`{"listeners": []}`:                                 "listeners",
+		`{"dir": "` + filepath.Join(dir, "missing") + `"}`:  "dir",
+		`{"dir": "` + filepath.Join(dir, "syslog") + `"}`:   "not a directory",
+		`{"symlinks": "always"}`:                            "symlinks",
+		`{"read_timeout_ms": 0}`:                            "timeout",
+		`{"chunk_size": -1}`:                                "chunk_size",
+		`{"backend": "sendfile"}`:                           "backend",
+		`{"limits": {"burst": -1}}`:                         "limits",
+		`{"limits": {"cached_files": -1}}`:                  "limits",
+		`{"tls": {"cert": "cert.pem"}}`:                     "tls",
+		`{"auth": {"client_ca": "ca.pem"}}`:                 "auth",
+		`{"files": [{"pattern": "[nginx"}]}`:                "files[0]",
+		`{"rate": 5`:                                        "unexpected EOF",
+		`{"roots": [{"name": "a/b"}]}`:                      "roots[0]: name",
+		`{"roots": [{"name": "app", "dir": "missing"}]}`:    "roots[0]: dir",
+		`{"roots": [{"name": "app", "partial": "some"}]}`:   "roots[0]: partial",
+		`{"roots": [{"name": "app", "record_start": "("}]}`: "roots[0]: record_start",
+		`{"roots": [{"name": "app"}, {"name": "app"}]}`:     "roots[1]: app is already a root",
+		`{"files": [{"pattern": "syslog", "principals": []}], "roots": [{"name": "app", "dir": "` + dir + `/"}]}`: "roots[0]: " + dir + "/ is already served",
+		`{"roots": [{"name": "app", "files": [{"pattern": "*"}]}]}`:                                               "roots[0]: " + dir + " is already served",
+	} {
+		_, err := loadConfig(writeConfig(t, dir, contents), base)
+		if assert.NotNil(t, err, contents) {
+			assert.Contains(t, err.Error(), message)
+		}
+	}
+
+	_, err := loadConfig(filepath.Join(dir, "missing.json"), base)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestWatchConfig(t *testing.T) {
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+	filename := writeConfig(t, dir, `{}`)
+
+	changed := make(chan struct{}, 10)
+	stop := make(chan struct{})
+	defer close(stop)
+	go watchConfig(filename, 10*time.Millisecond, func() { changed <- struct{}{} }, stop)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 0, len(changed))
+
+	writeConfig(t, dir, `{"chunk_size": 4096}`)
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("change not noticed")
+	}
+}
+
+func TestLoadConfig_Roots(t *testing.T) {
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+	base := testConfig(dir)
+	var flags rootFlags
+	assert.Nil(t, flags.Set("system="+dir))
+	assert.NotNil(t, flags.Set("system"))
+	base.Roots = flags
+
+	// the flags' roots stay unless the file has its own
+	loaded, err := loadConfig(writeConfig(t, dir, `{"chunk_size": 4096}`), base)
+	assert.Nil(t, err)
+	roots := loaded.namedRoots()
+	assert.Equal(t, 1, len(roots))
+	assert.Equal(t, "system", roots[0].name)
+	assert.Equal(t, dir, roots[0].resolver.Root())
+	assert.Equal(t, int64(4096), roots[0].chunkSize)
+
+	loaded, err = loadConfig(writeConfig(t, dir, `{"roots": [
+		{"name": "app", "dir": "`+filepath.Join(dir, "nginx")+`", "max_line_length": 0, "record_start": "^\\d", "files": [{"pattern": "*"}]}
+	]}`), base)
+	assert.Nil(t, err)
+	roots = loaded.namedRoots()
+	assert.Equal(t, 1, len(roots))
+	assert.Equal(t, "app", roots[0].name)
+	assert.Equal(t, filepath.Join(dir, "nginx"), roots[0].resolver.Root())
+	assert.Equal(t, 0, roots[0].maxLineLength)
+	assert.Equal(t, queryDefaults{recordStart: `^\d`}, roots[0].defaults)
+	assert.Equal(t, []filePolicy{{Pattern: "*"}}, roots[0].policies)
+	assert.Equal(t, "system="+dir, flags.String())
+
+	// a root serving the top level dir has its policies too
+	loaded, err = loadConfig(writeConfig(t, dir, `{"files": [{"pattern": "syslog", "principals": []}], "roots": [{"name": "sys"}]}`), base)
+	assert.Nil(t, err)
+	roots = loaded.namedRoots()
+	assert.Equal(t, loaded.Files, roots[0].policies)
+	router := newRouter(loaded.resolver(), loaded.queryLimits(), roots...)
+	response := executeRequest(httptest.NewRequest("GET", "/v1/roots/sys/files/syslog?lines=1", nil), router)
+	assert.Equal(t, http.StatusForbidden, response.Code)
+}
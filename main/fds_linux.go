@@ -0,0 +1,20 @@
+package main
+
+import (
+	"io/ioutil"
+	"syscall"
+)
+
+func openFileDescriptors() (int64, error) {
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	return int64(len(fds)), err
+}
+
+// the soft limit, what opening more runs into
+func fileDescriptorLimit() (int64, error) {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return 0, err
+	}
+	return int64(limit.Cur), nil
+}
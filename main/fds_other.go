@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+var errNoFileDescriptorCount = errors.New("open file descriptors are only counted on linux")
+
+func openFileDescriptors() (int64, error) {
+	return 0, errNoFileDescriptorCount
+}
+
+func fileDescriptorLimit() (int64, error) {
+	return 0, errNoFileDescriptorCount
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "os"
+
+// files are only told apart on linux; elsewhere queries aren't coalesced
+type fileID struct{}
+
+func fileIdentity(info os.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
@@ -1,102 +1,544 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"github.com/gorilla/mux"
 	"io"
+	"io/ioutil"
 	"log"
 	"log_monitor/monitor/chunk_reader"
+	"log_monitor/monitor/core"
 	"log_monitor/monitor/core_utils"
 	"log_monitor/monitor/file_reader"
+	"log_monitor/monitor/path_guard"
 	"net/http"
-	"path/filepath"
+	"os"
+	"regexp"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
-func CreateLogServer(dir string, address string, readTimeout uint, writeTimeout uint) http.Server {
-	return http.Server{
+func CreateLogServer(dir string, symlinks path_guard.SymlinkPolicy, limits queryLimits, address string, readTimeout uint, writeTimeout uint) *http.Server {
+	server, handler := newLogServer(address, time.Duration(readTimeout)*time.Millisecond, time.Duration(writeTimeout)*time.Millisecond)
+	handler.load(path_guard.NewResolver(dir, symlinks), limits)
+	return server
+}
+
+// the server's handler has to be loaded before serving, and can be loaded again at any time
+func newLogServer(address string, readTimeout time.Duration, writeTimeout time.Duration) (*http.Server, *reloadableHandler) {
+	handler := &reloadableHandler{status: &serverStatus{readTimeout: readTimeout, writeTimeout: writeTimeout}}
+	server := &http.Server{
 		Addr:         address,
-		Handler:      getRouter(dir),
-		ReadTimeout:  time.Duration(readTimeout) * time.Millisecond,
-		WriteTimeout: time.Duration(writeTimeout) * time.Millisecond,
+		Handler:      handler,
+		ReadTimeout:  readTimeout,
+		WriteTimeout: writeTimeout,
+		ConnContext:  withPeer,
 	}
+	server.RegisterOnShutdown(handler.status.shutdown)
+	return server, handler
 }
 
 func getRouter(dir string) *mux.Router {
+	return newRouter(path_guard.NewResolver(dir, path_guard.ContainedSymlinks), defaultLimits)
+}
+
+// bounds on what a single query takes, on how many are served, and on which files can be read
+type queryLimits struct {
+	chunkSize     int64 // read at a time; 0 is file_reader's default
+	maxLineLength int   // longer lines are truncated; 0 is no limit
+	backend       file_reader.Backend
+
+	rate               float64 // requests a second per client; 0 is no limit
+	burst              int     // requests a client can make at once after being idle
+	maxConcurrentReads int     // file reads running at once, across clients; 0 is no limit
+	queueTimeout       time.Duration
+
+	readRate       int64 // bytes a second a single read is paced to; 0 is no limit
+	globalReadRate int64 // bytes a second all reads together are paced to; 0 is no limit
+	cachedFiles    int   // files open at most, kept open for the reads after; 0 opens a file for every read
+
+	resultTTL       time.Duration // how long a query's result is served again to identical queries; 0 is not at all
+	resultCacheSize int64         // bytes of results kept for that in all
+
+	// shared by every request served by a router, and by the routers loaded after it (see carryOver);
+	// set up by setUp from the limits above unless given
+	admission      *admission
+	globalThrottle *chunk_reader.Throttle
+	files          *file_reader.FileCache
+	coalescer      *coalescer
+	metrics        *requestMetrics
+
+	policies []filePolicy
+	defaults queryDefaults
+}
+
+// what queries get for the parameters they leave out, as they would be given in the query
+type queryDefaults struct {
+	delimiter   string
+	recordStart string
+	partial     string
+}
+
+var defaultLimits = queryLimits{maxLineLength: 1 << 20, cachedFiles: 64, resultCacheSize: 64 << 20}
+
+// serves resolver's directory under /v1/files, and each of roots under /v1/roots/{root}/files
+func newRouter(resolver path_guard.Resolver, limits queryLimits, roots ...namedRoot) *mux.Router {
 	router := mux.NewRouter()
-	router.HandleFunc("/{file}", serveLinesThenFilter(dir)).Queries("lines", "{lines}").Queries("filter", "{filter}").Methods("GET")
-	router.HandleFunc("/{file}", serveNLines(dir)).Queries("lines", "{lines}").Methods("GET")
-	router.HandleFunc("/{file}", serveFilterLines(dir)).Queries("filter", "{filter}").Methods("GET")
+	// the resolver sees the path as sent, so it can refuse traversal instead of it being cleaned or decoded away
+	router.UseEncodedPath()
+	router.SkipClean(true)
+	limits = limits.setUp()
+	admission, metrics := limits.admission, limits.metrics
+	// before the files, a file called metrics is still under /v1/files. neither it nor the routes added
+	// to router afterwards (addStatusRoutes) are rate limited or measured
+	router.HandleFunc("/metrics", metrics.serve).Methods("GET")
+
+	files := router.NewRoute().Subrouter()
+	files.Use(metrics.measure, withPrincipal, admission.limitRate)
+	addFileRoutes(files, resolver, limits, "/v1/files/{path:.*}", "/{path}")
+
+	files.HandleFunc("/v1/roots", serveRoots(roots)).Methods("GET").Name("roots")
+	for _, root := range roots {
+		addFileRoutes(files, root.resolver, root.limits(limits), "/v1/roots/"+root.name+"/files/{path:.*}")
+	}
 	return router
 }
 
-func serveNLines(baseDir string) http.HandlerFunc {
+// the state shared by requests that isn't given already
+func (limits queryLimits) setUp() queryLimits {
+	if limits.admission == nil {
+		limits.admission = newAdmission(limits)
+	}
+	if limits.globalThrottle == nil {
+		limits.globalThrottle = chunk_reader.NewThrottle(limits.globalReadRate)
+	}
+	if limits.files == nil {
+		limits.files = file_reader.NewFileCache(limits.cachedFiles)
+	}
+	if limits.coalescer == nil {
+		limits.coalescer = newCoalescer(limits.resultTTL, limits.resultCacheSize)
+	}
+	if limits.metrics == nil {
+		limits.metrics = newRequestMetrics()
+	}
+	limits.metrics.setFiles(limits.files)
+	return limits
+}
+
+// anything in the tree under the served directory, directories are listed under the first path
+func addFileRoutes(files *mux.Router, resolver path_guard.Resolver, limits queryLimits, paths ...string) {
+	for _, path := range paths {
+		files.Handle(path, serveLinesThenFilter(resolver, limits)).Queries("lines", "{lines}").Queries("filter", "{filter}").Methods("GET").Name("lines_filter")
+		files.Handle(path, serveNLines(resolver, limits)).Queries("lines", "{lines}").Methods("GET").Name("lines")
+		files.Handle(path, serveFilterLines(resolver, limits)).Queries("filter", "{filter}").Methods("GET").Name("filter")
+	}
+	files.HandleFunc(paths[0], serveListing(resolver, limits)).Methods("GET").Name("list")
+}
+
+const defaultListDepth = 3
+const maxListDepth = 8
+
+func serveListing(resolver path_guard.Resolver, limits queryLimits) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		path, n, err := nLinesParse(baseDir, r)
+		depth, err := listDepthParse(r)
 		if err != nil {
-			http.NotFound(w, r)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		res, err := file_reader.ReadReverseNLinesChunk(path, n)
+		entries, err := resolver.List(mux.Vars(r)["path"], depth)
 		if err != nil {
-			http.NotFound(w, r)
+			serveOpenError(w, r, err)
 			return
 		}
-		io.Copy(w, res)
+		for _, entry := range filterListing(limits.policies, entries, getPrincipal(r), resolver.ResolveRelative) {
+			io.WriteString(w, entry+"\n")
+		}
 	}
 }
 
-func serveFilterLines(baseDir string) http.HandlerFunc {
+// a query that reads from an opened, served file
+type fileQuery func(file *os.File, r *http.Request, options chunk_reader.Options, report *chunk_reader.Report) (io.ReadSeeker, error)
+
+func serveFileQuery(resolver path_guard.Resolver, limits queryLimits, query fileQuery) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		path, filter := filterLinesParse(baseDir, r)
-		res, err := file_reader.ReadReversePassesFilterChunk(path, filter)
+		delimiter, err := delimiterParse(r, limits.defaults.delimiter)
 		if err != nil {
-			http.NotFound(w, r)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		io.Copy(w, res)
-	}
-}
+		recordStart, err := recordStartParse(r, limits.defaults.recordStart)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		partial, err := chunk_reader.ParsePartial(queryParam(r, "partial", limits.defaults.partial))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		shared, err := openRequestFile(resolver, limits, r)
+		if err != nil {
+			serveOpenError(w, r, err)
+			return
+		}
+		// released as soon as the file is read, not once the response is written; once the query is run
+		// (see coalescer), by the run
+		owned := true
+		defer func() {
+			if owned {
+				shared.Release()
+			}
+		}()
+		file := shared.File()
 
-func serveLinesThenFilter(baseDir string) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		path, n, err := nLinesParse(baseDir, r)
-		_, filter := filterLinesParse(baseDir, r)
+		force := forceParse(r)
+		binary, err := file_reader.IsBinary(file, delimiter.Separator())
 		if err != nil {
 			http.NotFound(w, r)
 			return
+		} else if binary && !force {
+			http.Error(w, "binary file; use force=1 to read it anyway", http.StatusUnsupportedMediaType)
+			return
 		}
 
-		res, err := file_reader.ReadReverseNLinesChunk(path, n)
-		res, err = core_utils.LogFuncBind(res, err, func(buf io.ReadSeeker) (io.ReadSeeker, error) {
-			return chunk_reader.ReadReversePassesFilter(buf, filter, 64000)
+		options := chunk_reader.Options{
+			Chunk:       limits.chunkSize,
+			SkipHoles:   !binary, // NULs in a binary file are data, not holes
+			Delimiter:   delimiter,
+			RecordStart: recordStart,
+			Partial:     partial,
+			MaxLength:   limits.maxLineLength,
+			Throttles:   []*chunk_reader.Throttle{chunk_reader.NewThrottle(limits.readRate), limits.globalThrottle},
+		}
+		key, hasKey := queryKeyOf(resolver, limits, file, r)
+		owned = false
+		// canceled once the client went away, or the server gave up draining on shutdown; for a
+		// coalesced query, once every one of its clients did
+		contents, report, led, err := limits.coalescer.do(key, hasKey, r.Context().Done(), func(cancel <-chan struct{}) ([]byte, chunk_reader.Report, error) {
+			defer shared.Release()
+			release, err := limits.admission.acquireRead(cancel)
+			if err != nil {
+				return nil, chunk_reader.Report{}, err
+			}
+			defer release()
+			options.Cancel = cancel
+			var report chunk_reader.Report
+			res, err := query(file, r, options, &report)
+			if err != nil {
+				return nil, report, err
+			}
+			contents, err := ioutil.ReadAll(res)
+			return contents, report, err
 		})
-		if err != nil {
+		if !led {
+			shared.Release()
+		}
+		if entry := accessEntryOf(r); entry != nil && led {
+			entry.Scanned = report.BytesRead
+		}
+		if err == errQueueFull {
+			tooManyRequests(w, time.Second)
+			return
+		} else if err != nil {
 			http.NotFound(w, r)
 			return
 		}
-		io.Copy(w, res)
+		writeReportHeaders(w, report)
+		if force {
+			contents = core_utils.EscapeNonPrintable(contents)
+		}
+		w.Write(contents)
+	}
+}
+
+// identical queries on the same file, as it is now, with the same settings for what they leave out, get
+// the same result (see coalescer); roots can serve the same directory with different ones. The client's
+// policies have been checked already
+func queryKeyOf(resolver path_guard.Resolver, limits queryLimits, file *os.File, r *http.Request) (queryKey, bool) {
+	info, err := file.Stat()
+	if err != nil {
+		return queryKey{}, false
+	}
+	id, ok := fileIdentity(info)
+	if !ok {
+		return queryKey{}, false
+	}
+	query := strings.Join([]string{
+		resolver.Root(), mux.CurrentRoute(r).GetName(), mux.Vars(r)["path"], r.URL.RawQuery,
+		limits.defaults.delimiter, limits.defaults.recordStart, limits.defaults.partial,
+		strconv.Itoa(limits.maxLineLength), strconv.FormatInt(limits.chunkSize, 10),
+	}, "\x00")
+	return queryKey{query: query, file: id, size: info.Size()}, true
+}
+
+// holes are given as offset:length pairs; a partial record as its length; throttling as the rate in
+// bytes a second and the milliseconds spent waiting on it
+func writeReportHeaders(w http.ResponseWriter, report chunk_reader.Report) {
+	if report.ThrottleRate > 0 {
+		w.Header().Set("X-Log-Throttle-Rate", strconv.FormatInt(report.ThrottleRate, 10))
+		w.Header().Set("X-Log-Throttle-Wait", strconv.FormatInt(report.ThrottleWait.Milliseconds(), 10))
+	}
+	if report.Partial > 0 {
+		w.Header().Set("X-Log-Partial", strconv.FormatInt(report.Partial, 10))
+	}
+	if len(report.Holes) > 0 {
+		holes := make([]string, 0, len(report.Holes))
+		for _, hole := range report.Holes {
+			holes = append(holes, strconv.FormatInt(hole.Offset, 10)+":"+strconv.FormatInt(hole.Length, 10))
+		}
+		w.Header().Set("X-Log-Holes", strings.Join(holes, ","))
+	}
+}
+
+func serveNLines(resolver path_guard.Resolver, limits queryLimits) http.HandlerFunc {
+	return serveFileQuery(resolver, limits, func(file *os.File, r *http.Request, options chunk_reader.Options, report *chunk_reader.Report) (io.ReadSeeker, error) {
+		n, err := nLinesParse(r)
+		if err != nil {
+			return nil, err
+		}
+		return file_reader.ReadReverseNLinesFile(file, n, limits.backend, options, report)
+	})
+}
+
+func serveFilterLines(resolver path_guard.Resolver, limits queryLimits) http.HandlerFunc {
+	return serveFileQuery(resolver, limits, func(file *os.File, r *http.Request, options chunk_reader.Options, report *chunk_reader.Report) (io.ReadSeeker, error) {
+		return file_reader.ReadReversePassesFilterFile(file, filterLinesParse(r), limits.backend, options, report)
+	})
+}
+
+func serveLinesThenFilter(resolver path_guard.Resolver, limits queryLimits) http.HandlerFunc {
+	return serveFileQuery(resolver, limits, func(file *os.File, r *http.Request, options chunk_reader.Options, report *chunk_reader.Report) (io.ReadSeeker, error) {
+		n, err := nLinesParse(r)
+		if err != nil {
+			return nil, err
+		}
+		filter := filterLinesParse(r)
+
+		res, err := file_reader.ReadReverseNLinesFile(file, n, limits.backend, options, report)
+		// the lines are already truncated
+		return core_utils.LogFuncBind(res, err, func(buf io.ReadSeeker) (io.ReadSeeker, error) {
+			return chunk_reader.ReadReversePassesFilterWith(buf, filter, chunk_reader.Options{Chunk: 64000, Delimiter: options.Delimiter.Output(), RecordStart: options.RecordStart}, nil)
+		})
+	})
+}
+
+func nLinesParse(r *http.Request) (uint64, error) {
+	return strconv.ParseUint(mux.Vars(r)["lines"], 10, 64)
+}
+
+func filterLinesParse(r *http.Request) string {
+	return mux.Vars(r)["filter"]
+}
+
+// fallback if the query doesn't give the parameter
+func queryParam(r *http.Request, name string, fallback string) string {
+	if values, ok := r.URL.Query()[name]; ok {
+		return values[0]
+	}
+	return fallback
+}
+
+func delimiterParse(r *http.Request, fallback string) (core.Delimiter, error) {
+	return core.ParseDelimiter(queryParam(r, "delimiter", fallback))
+}
+
+// lines that don't match belong to the record before them, e.g. the frames of a stack trace
+func recordStartParse(r *http.Request, fallback string) (*regexp.Regexp, error) {
+	query := queryParam(r, "record_start", fallback)
+	if query == "" {
+		return nil, nil
+	}
+	return regexp.Compile(query)
+}
+
+func forceParse(r *http.Request) bool {
+	return r.URL.Query().Get("force") == "1"
+}
+
+func listDepthParse(r *http.Request) (int, error) {
+	query := r.URL.Query().Get("depth")
+	if query == "" {
+		return defaultListDepth, nil
+	}
+	depth, err := strconv.Atoi(query)
+	if err != nil || depth < 1 || depth > maxListDepth {
+		return 0, errors.New("depth must be between 1 and " + strconv.Itoa(maxListDepth))
 	}
+	return depth, nil
 }
 
-func nLinesParse(baseDir string, r *http.Request) (string, uint64, error) {
-	vars := mux.Vars(r)
-	nLines, err := strconv.ParseUint(vars["lines"], 10, 64)
-	return filepath.Join(baseDir, vars["file"]), nLines, err
+// the file is shared with the other requests reading it (see file_reader.FileCache)
+func openRequestFile(resolver path_guard.Resolver, limits queryLimits, r *http.Request) (*file_reader.SharedFile, error) {
+	escaped := mux.Vars(r)["path"]
+	relative, err := path_guard.Relative(escaped)
+	if err != nil {
+		return nil, err
+	}
+	entry := accessEntryOf(r)
+	if entry != nil {
+		entry.File = relative
+	}
+	// the path asked for is checked before the disk is touched, the one it resolves to once it's known
+	policy := matchPolicy(limits.policies, relative)
+	if entry != nil {
+		entry.audit = policy != nil && policy.Audit
+	}
+	if policy != nil && !policy.allows(getPrincipal(r)) {
+		return nil, path_guard.ErrForbidden
+	}
+	resolved, err := resolver.Resolve(escaped)
+	if err != nil {
+		return nil, err
+	}
+	if policy := matchPolicy(limits.policies, resolved); policy != nil {
+		if entry != nil && policy.Audit {
+			entry.audit = true
+		}
+		if !policy.allows(getPrincipal(r)) {
+			return nil, path_guard.ErrForbidden
+		}
+	}
+	// what was checked is what is opened, symlinks along resolved are refused
+	return limits.files.Open(resolver.Root()+"/"+resolved, func() (*os.File, error) {
+		return resolver.OpenResolved(resolved)
+	})
 }
 
-func filterLinesParse(baseDir string, r *http.Request) (string, string) {
-	vars := mux.Vars(r)
-	return filepath.Join(baseDir, vars["file"]), vars["filter"]
+func serveOpenError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, path_guard.ErrForbidden) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	} else if err == file_reader.ErrTooManyFiles {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	http.NotFound(w, r)
 }
 
 func main() {
-	addr := flag.String("addr", "localhost:8080", "address:port to run server")
+	configFile := flag.String("config", "", "json config file (see README), overriding the flags it sets; reloaded on SIGHUP or when it changes")
+	addr := flag.String("addr", "localhost:8080", "address:port to run server, unix:PATH for a unix socket, or systemd for the sockets passed by systemd socket activation")
+	socketMode := flag.String("socket-mode", "0660", "octal permissions of the unix socket with -addr unix:PATH")
 	dir := flag.String("dir", "/var/log", "default serving directory")
+	readTimeout := flag.Uint("read-timeout", 100, "timeout in milliseconds to read a request")
 	timeout := flag.Uint("timeout", 2000, "timeout in milliseconds to serve a request")
+	tlsCert := flag.String("tls-cert", "", "certificate file to serve https with")
+	tlsKey := flag.String("tls-key", "", "private key file for -tls-cert")
+	clientCA := flag.String("client-ca", "", "ca certificates file; clients must present a certificate signed by one")
+	symlinks := flag.String("symlinks", "contained", "refuse: never follow symlinks; contained: follow symlinks that stay under dir")
+	chunkSize := flag.Int64("chunk-size", 0, "bytes read from a file at a time; 0 for the default")
+	backend := flag.String("backend", "chunk", "chunk: read files a chunk at a time; mmap: map them and parse them in place")
+	maxLineLength := flag.Int("max-line-length", defaultLimits.maxLineLength, "lines longer than this many bytes are truncated; 0 for no limit")
+	rate := flag.Float64("rate", 20, "requests a second allowed per client (principal, or address); 0 for no limit")
+	burst := flag.Int("burst", 40, "requests a client can make at once before -rate applies")
+	maxConcurrentReads := flag.Int("max-concurrent-reads", 32, "file reads running at once; others wait for up to -queue-timeout. 0 for no limit")
+	queueTimeout := flag.Uint("queue-timeout", 1000, "milliseconds a read waits to start before giving up with 429")
+	readRate := flag.Int64("read-rate", 0, "bytes a second a single request reads from disk; 0 for no limit")
+	globalReadRate := flag.Int64("global-read-rate", 0, "bytes a second all requests together read from disk; 0 for no limit")
+	resultCache := flag.Uint("result-cache", 0, "milliseconds a query's result is served again to identical queries while the file is unchanged; 0 for never")
+	resultCacheSize := flag.Int64("result-cache-size", defaultLimits.resultCacheSize, "bytes of results -result-cache keeps in all, the oldest are dropped first")
+	cachedFiles := flag.Int("cached-files", defaultLimits.cachedFiles, "files open at most, kept open between reads and shared by the requests reading them at once; 0 to open a file for every read, with no limit")
+	var roots rootFlags
+	flag.Var(&roots, "root", "name=dir, served under /v1/roots/name/files; can be given more than once")
+	accessLog := flag.String("access-log", "", "file requests are logged to as json lines")
+	auditLog := flag.String("audit-log", "", "file reads of files with an audit policy are logged to, hash chained")
+	auditKey := flag.String("audit-key", "", "file with a key to make -audit-log's hashes HMACs")
+	auditMaxSize := flag.Int64("audit-max-size", 100<<20, "bytes -audit-log grows to before being rotated; 0 for never")
+	auditKeep := flag.Int("audit-keep", 5, "rotated -audit-log files kept")
+	drainTimeout := flag.Uint("drain-timeout", 5000, "milliseconds requests in flight get to finish on SIGTERM or SIGINT before their reads are canceled")
 	flag.Parse()
 
-	server := CreateLogServer(*dir, *addr, 100, *timeout)
-	log.Fatal(server.ListenAndServe())
+	flags := config{
+		Listeners:    []string{*addr},
+		SocketMode:   *socketMode,
+		Dir:          *dir,
+		Symlinks:     *symlinks,
+		ReadTimeout:  *readTimeout,
+		WriteTimeout: *timeout,
+		DrainTimeout: *drainTimeout,
+		ChunkSize:    *chunkSize,
+		Backend:      *backend,
+		Limits: limitsConfig{
+			MaxLineLength:      *maxLineLength,
+			Rate:               *rate,
+			Burst:              *burst,
+			MaxConcurrentReads: *maxConcurrentReads,
+			QueueTimeout:       *queueTimeout,
+			ReadRate:           *readRate,
+			GlobalReadRate:     *globalReadRate,
+			CachedFiles:        *cachedFiles,
+			ResultCache:        *resultCache,
+			ResultCacheSize:    *resultCacheSize,
+		},
+		TLS:       tlsFiles{Cert: *tlsCert, Key: *tlsKey},
+		Auth:      authConfig{ClientCA: *clientCA},
+		Roots:     roots,
+		AccessLog: *accessLog,
+		AuditLog:  auditConfig{File: *auditLog, KeyFile: *auditKey, MaxSize: *auditMaxSize, Keep: *auditKeep},
+	}
+	current := flags
+	err := flags.validate()
+	if *configFile != "" {
+		current, err = loadConfig(*configFile, flags)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	server, handler := newLogServer(current.Listeners[0], time.Duration(current.ReadTimeout)*time.Millisecond, time.Duration(current.WriteTimeout)*time.Millisecond)
+	handler.load(current.resolver(), current.queryLimits(), current.namedRoots()...)
+	if handler.logs, err = current.openLogs(); err != nil {
+		log.Fatal(err)
+	}
+
+	listeners, err := listen(current.Listeners, current.socketMode())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var reloader *tlsReloader
+	if current.useTLS() {
+		reloader, err = newTLSReloader(current.TLS.Cert, current.TLS.Key, current.Auth.ClientCA)
+		if err != nil {
+			log.Fatal(err)
+		}
+		server.TLSConfig = reloader.Config()
+	}
+
+	// SIGHUP and the config file changing can come together
+	var reloading sync.Mutex
+	reload := func() {
+		reloading.Lock()
+		defer reloading.Unlock()
+
+		if *configFile != "" {
+			next, err := loadConfig(*configFile, flags)
+			if err != nil {
+				log.Println("config reload failed, keeping the current config:", err)
+			} else {
+				if changed := current.needsRestart(next); len(changed) > 0 {
+					log.Println("changes to", strings.Join(changed, ", "), "take effect on restart")
+				}
+				handler.load(next.resolver(), next.queryLimits(), next.namedRoots()...)
+				current = next
+				log.Println("config reloaded")
+			}
+		}
+		if reloader != nil {
+			if err := reloader.Reload(); err != nil {
+				log.Println("tls reload failed:", err)
+			}
+		}
+	}
+	if *configFile != "" {
+		go watchConfig(*configFile, time.Second, reload, nil)
+	}
+
+	if err := serveUntilSignalled(server, listeners, current.useTLS(), reload, time.Duration(current.DrainTimeout)*time.Millisecond); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -3,10 +3,19 @@ package main
 import (
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"log_monitor/monitor/file_reader"
+	"log_monitor/monitor/path_guard"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestNonExistentFile(t *testing.T) {
@@ -75,6 +84,359 @@ func TestExistentFile_ValidQuery_InvalidMethod(t *testing.T) {
 	testMethod("OPTIONS")
 }
 
+func TestPathEscapes_Forbidden(t *testing.T) {
+	for _, path := range []string{
+		"/..?lines=1",
+		"/%2e%2e?lines=1",
+		"/..%2Ffile_reader%2Fsyslog_ex?lines=1",
+		"/..%2ffile_reader%2fsyslog_ex?filter=a",
+		"/..%5Cfile_reader%5Csyslog_ex?lines=1&filter=a",
+		"/syslog_ex%00?lines=1",
+	} {
+		res, err := http.NewRequest("GET", path, nil)
+		assert.Nil(t, err)
+
+		response := executeRequest(res, getRouter("../files/"))
+		assert.Equal(t, http.StatusForbidden, response.Code, path)
+	}
+}
+
+func TestPathEscapes_Symlink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "http_server")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "secret"), []byte("secret\n"), 0600))
+	assert.Nil(t, os.Mkdir(filepath.Join(dir, "logs"), 0700))
+	assert.Nil(t, os.Symlink("../secret", filepath.Join(dir, "logs", "escape")))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "logs", "log"), []byte("abc\n"), 0600))
+	assert.Nil(t, os.Symlink("log", filepath.Join(dir, "logs", "inside")))
+
+	for _, policy := range []path_guard.SymlinkPolicy{path_guard.RefuseSymlinks, path_guard.ContainedSymlinks} {
+		router := newRouter(path_guard.NewResolver(filepath.Join(dir, "logs"), policy), defaultLimits)
+
+		res, err := http.NewRequest("GET", "/escape?lines=1", nil)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusForbidden, executeRequest(res, router).Code)
+	}
+
+	res, err := http.NewRequest("GET", "/inside?lines=1", nil)
+	assert.Nil(t, err)
+	response := executeRequest(res, newRouter(path_guard.NewResolver(filepath.Join(dir, "logs"), path_guard.ContainedSymlinks), defaultLimits))
+	assert.Equal(t, "abc\n", response.Body.String())
+	response = executeRequest(res, newRouter(path_guard.NewResolver(filepath.Join(dir, "logs"), path_guard.RefuseSymlinks), defaultLimits))
+	assert.Equal(t, http.StatusForbidden, response.Code)
+}
+
+// a /var/log like layout
+func createLogTree(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "http_server")
+	assert.Nil(t, err)
+	for _, sub := range []string{"apache2", "nginx", "installer/subiquity"} {
+		assert.Nil(t, os.MkdirAll(filepath.Join(dir, sub), 0700))
+	}
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "syslog"), []byte("abc\ndef\n"), 0600))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "nginx", "access.log"), []byte("GET /\nPOST /login\nGET /index\n"), 0600))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "installer", "subiquity", "server.log"), []byte("start\n"), 0600))
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func TestNestedFiles(t *testing.T) {
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+	router := getRouter(dir)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		res, err := http.NewRequest("GET", path, nil)
+		assert.Nil(t, err)
+		return executeRequest(res, router)
+	}
+
+	assert.Equal(t, "GET /index\nPOST /login\n", get("/v1/files/nginx/access.log?lines=2").Body.String())
+	assert.Equal(t, "GET /index\nGET /\n", get("/v1/files/nginx/access.log?filter=GET").Body.String())
+	assert.Equal(t, "GET /index\n", get("/v1/files/nginx/access.log?lines=2&filter=GET").Body.String())
+	assert.Equal(t, "start\n", get("/v1/files/installer/subiquity/server.log?lines=10").Body.String())
+	assert.Equal(t, "def\n", get("/v1/files/syslog?lines=1").Body.String())
+
+	assert.Equal(t, http.StatusNotFound, get("/v1/files/nginx/missing.log?lines=1").Code)
+	assert.Equal(t, http.StatusForbidden, get("/v1/files/nginx/../syslog?lines=1").Code)
+	assert.Equal(t, http.StatusForbidden, get("/v1/files/nginx%2F..%2Fsyslog?lines=1").Code)
+	assert.Equal(t, http.StatusForbidden, get("/v1/files/nginx?lines=1").Code)
+}
+
+func TestListing(t *testing.T) {
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+	router := getRouter(dir)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		res, err := http.NewRequest("GET", path, nil)
+		assert.Nil(t, err)
+		return executeRequest(res, router)
+	}
+
+	assert.Equal(t, "apache2/\ninstaller/\ninstaller/subiquity/\ninstaller/subiquity/server.log\nnginx/\nnginx/access.log\nsyslog\n", get("/v1/files/").Body.String())
+	assert.Equal(t, "apache2/\ninstaller/\nnginx/\nsyslog\n", get("/v1/files/?depth=1").Body.String())
+	assert.Equal(t, "installer/subiquity/\n", get("/v1/files/installer?depth=1").Body.String())
+	assert.Equal(t, "nginx/access.log\n", get("/v1/files/nginx/").Body.String())
+
+	assert.Equal(t, http.StatusBadRequest, get("/v1/files/?depth=0").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/v1/files/?depth=100").Code)
+	assert.Equal(t, http.StatusNotFound, get("/v1/files/missing/").Code)
+	assert.Equal(t, http.StatusNotFound, get("/v1/files/syslog").Code)
+	assert.Equal(t, http.StatusForbidden, get("/v1/files/..").Code)
+}
+
+func TestBinaryFile(t *testing.T) {
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "wtmp"), []byte("\x07\x00\x00\x00root\x00pts/0\n\x01\x02tty\n"), 0600))
+	router := getRouter(dir)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		res, err := http.NewRequest("GET", path, nil)
+		assert.Nil(t, err)
+		return executeRequest(res, router)
+	}
+
+	for _, path := range []string{"/wtmp?lines=1", "/wtmp?filter=root", "/v1/files/wtmp?lines=1&filter=tty", "/wtmp?lines=1&force=0"} {
+		assert.Equal(t, http.StatusUnsupportedMediaType, get(path).Code, path)
+	}
+
+	response := get("/wtmp?lines=2&force=1")
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, `\x01\x02tty`+"\n"+`\x07\x00\x00\x00root\x00pts/0`+"\n", response.Body.String())
+	assert.Equal(t, `\x07\x00\x00\x00root\x00pts/0`+"\n", get("/wtmp?filter=root&force=1").Body.String())
+
+	// text is the same either way
+	assert.Equal(t, "def\n", get("/syslog?lines=1&force=1").Body.String())
+}
+
+// logrotate copytruncate with the writer keeping its old offset
+func TestCopyTruncateHole(t *testing.T) {
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+	contents := append(make([]byte, 100), []byte("new\nlines\n")...)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "rotated"), contents, 0600))
+	router := getRouter(dir)
+
+	res, err := http.NewRequest("GET", "/rotated?lines=10", nil)
+	assert.Nil(t, err)
+	response := executeRequest(res, router)
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "lines\nnew\n", response.Body.String())
+	assert.Equal(t, "0:100", response.Header().Get("X-Log-Holes"))
+
+	res, err = http.NewRequest("GET", "/rotated?lines=1", nil)
+	assert.Nil(t, err)
+	response = executeRequest(res, router)
+	assert.Equal(t, "lines\n", response.Body.String())
+	// the one chunk read covers the hole
+	assert.Equal(t, "0:100", response.Header().Get("X-Log-Holes"))
+}
+
+func TestDelimiters(t *testing.T) {
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "windows.log"), []byte("one\r\ntwo\r\nthree\r\n"), 0600))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "print0"), []byte("a b\x00c\nd\x00e\x00"), 0600))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "pipes"), []byte("x|y|xy|"), 0600))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "percent"), []byte("x%y%xy%"), 0600))
+	router := getRouter(dir)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		res, err := http.NewRequest("GET", path, nil)
+		assert.Nil(t, err)
+		return executeRequest(res, router)
+	}
+
+	assert.Equal(t, "three\r\ntwo\r\n", get("/windows.log?lines=2").Body.String())
+	assert.Equal(t, "three\ntwo\n", get("/windows.log?lines=2&delimiter=crlf").Body.String())
+	assert.Equal(t, "two\n", get("/windows.log?lines=2&filter=w&delimiter=crlf").Body.String())
+
+	assert.Equal(t, http.StatusUnsupportedMediaType, get("/print0?lines=2").Code)
+	assert.Equal(t, "e\x00c\nd\x00", get("/print0?lines=2&delimiter=nul").Body.String())
+	assert.Equal(t, "c\nd\x00", get("/print0?filter=c&delimiter=%00").Body.String())
+
+	assert.Equal(t, "xy|x|", get("/pipes?filter=x&delimiter=%7C").Body.String())
+	assert.Equal(t, http.StatusBadRequest, get("/pipes?filter=x&delimiter=ab").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/pipes?filter=x&delimiter=%257C").Code)
+	assert.Equal(t, "xy%x%", get("/percent?filter=x&delimiter=%25").Body.String())
+}
+
+func TestRecordStart(t *testing.T) {
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+	exception := "2021-02-25 10:00:01 java.lang.NullPointerException\n" +
+		"\tat Foo.bar(Foo.java:10)\n" +
+		"\tat Foo.main(Foo.java:3)\n"
+	contents := "2021-02-25 10:00:00 start\n" + exception + "2021-02-25 10:00:02 done\n"
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "app.log"), []byte(contents), 0600))
+	router := getRouter(dir)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		res, err := http.NewRequest("GET", path, nil)
+		assert.Nil(t, err)
+		return executeRequest(res, router)
+	}
+
+	start := "record_start=" + url.QueryEscape(`^\d{4}-\d{2}-\d{2} `)
+	assert.Equal(t, exception, get("/app.log?filter=NullPointerException&"+start).Body.String())
+	assert.Equal(t, exception, get("/app.log?filter=Foo.main&"+start).Body.String())
+	assert.Equal(t, "2021-02-25 10:00:02 done\n"+exception, get("/app.log?lines=2&"+start).Body.String())
+	assert.Equal(t, exception, get("/app.log?lines=2&filter=Foo.bar&"+start).Body.String())
+
+	// without it lines are records
+	assert.Equal(t, "\tat Foo.main(Foo.java:3)\n", get("/app.log?filter=Foo.main").Body.String())
+	assert.Equal(t, http.StatusBadRequest, get("/app.log?lines=2&record_start=%28").Code)
+}
+
+func TestPartial(t *testing.T) {
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "progress.log"), []byte("start\n50%"), 0600))
+	router := getRouter(dir)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		res, err := http.NewRequest("GET", path, nil)
+		assert.Nil(t, err)
+		return executeRequest(res, router)
+	}
+
+	response := get("/progress.log?lines=1")
+	assert.Equal(t, "start\n", response.Body.String())
+	assert.Equal(t, "", response.Header().Get("X-Log-Partial"))
+	assert.Equal(t, "start\n", get("/progress.log?lines=1&partial=exclude").Body.String())
+
+	response = get("/progress.log?lines=1&partial=include")
+	assert.Equal(t, "50%\n", response.Body.String())
+	assert.Equal(t, "3", response.Header().Get("X-Log-Partial"))
+	assert.Equal(t, "50%\n", get("/progress.log?filter=%25&partial=include").Body.String())
+
+	assert.Equal(t, http.StatusBadRequest, get("/progress.log?lines=1&partial=yes").Code)
+}
+
+func TestMaxLineLength(t *testing.T) {
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+	long := strings.Repeat("{\"key\": \"value\"}, ", 1000)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "dump.log"), []byte("before\n"+long+"\nafter\n"), 0600))
+	router := newRouter(path_guard.NewResolver(dir, path_guard.ContainedSymlinks), queryLimits{maxLineLength: 16})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		res, err := http.NewRequest("GET", path, nil)
+		assert.Nil(t, err)
+		return executeRequest(res, router)
+	}
+
+	truncated := "{\"key\": \"value\"}...[truncated, " + strconv.Itoa(len(long)) + " bytes]\n"
+	assert.Equal(t, "after\n"+truncated+"before\n", get("/dump.log?lines=3").Body.String())
+	assert.Equal(t, truncated, get("/dump.log?lines=3&filter=key").Body.String())
+	assert.Equal(t, truncated, get("/dump.log?filter=key").Body.String())
+
+	// the default is well past it
+	res, err := http.NewRequest("GET", "/dump.log?filter=key", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, long+"\n", executeRequest(res, getRouter(dir)).Body.String())
+}
+
+func TestReadRate(t *testing.T) {
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "big.log"), []byte(strings.Repeat("0123456789abcdef\n", 10000)), 0600))
+
+	get := func(router *mux.Router, path string) *httptest.ResponseRecorder {
+		res, err := http.NewRequest("GET", path, nil)
+		assert.Nil(t, err)
+		return executeRequest(res, router)
+	}
+
+	response := get(getRouter(dir), "/big.log?filter=f")
+	assert.Equal(t, "", response.Header().Get("X-Log-Throttle-Rate"))
+
+	// 170000 bytes, the first 64000 byte chunk isn't waited on
+	router := newRouter(path_guard.NewResolver(dir, path_guard.ContainedSymlinks), queryLimits{readRate: 4000000, globalReadRate: 2000000})
+	response = get(router, "/big.log?filter=f")
+	assert.Equal(t, 10000, strings.Count(response.Body.String(), "\n"))
+	assert.Equal(t, "2000000", response.Header().Get("X-Log-Throttle-Rate"))
+	wait, err := strconv.Atoi(response.Header().Get("X-Log-Throttle-Wait"))
+	assert.Nil(t, err)
+	assert.True(t, wait >= 50, wait)
+}
+
+func TestMmapBackend(t *testing.T) {
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+	contents := append(make([]byte, 100), []byte(strings.Repeat("0123456789abcdef\n", 10000)+"partial")...)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "big.log"), contents, 0600))
+
+	chunked := getRouter(dir)
+	mapped := newRouter(path_guard.NewResolver(dir, path_guard.ContainedSymlinks), queryLimits{backend: file_reader.MmapBackend, maxLineLength: defaultLimits.maxLineLength})
+	for _, query := range []string{"lines=10", "lines=100000", "filter=f", "lines=20&filter=9", "lines=5&partial=include"} {
+		res, err := http.NewRequest("GET", "/big.log?"+query, nil)
+		assert.Nil(t, err)
+		expected := executeRequest(res, chunked)
+		response := executeRequest(res, mapped)
+		assert.Equal(t, http.StatusOK, response.Code, query)
+		assert.Equal(t, expected.Body.String(), response.Body.String(), query)
+		assert.Equal(t, expected.Header().Get("X-Log-Holes"), response.Header().Get("X-Log-Holes"), query)
+		assert.Equal(t, expected.Header().Get("X-Log-Partial"), response.Header().Get("X-Log-Partial"), query)
+	}
+}
+
+// the requests share one open file, which they read at the same time
+func TestCachedFiles(t *testing.T) {
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+	contents := strings.Repeat("0123456789abcdef\n", 10000)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "big.log"), []byte(contents), 0600))
+
+	limits := defaultLimits
+	limits.chunkSize = 1000
+	router := newRouter(path_guard.NewResolver(dir, path_guard.ContainedSymlinks), limits)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(query string) {
+			defer wg.Done()
+			request, err := http.NewRequest("GET", "/big.log?"+query, nil)
+			assert.Nil(t, err)
+			response := executeRequest(request, router)
+			assert.Equal(t, http.StatusOK, response.Code)
+			assert.Equal(t, 10000, strings.Count(response.Body.String(), "\n"), query)
+		}([]string{"lines=10000", "filter=f"}[i%2])
+	}
+	wg.Wait()
+
+	request, err := http.NewRequest("GET", "/metrics", nil)
+	assert.Nil(t, err)
+	assert.Contains(t, executeRequest(request, router).Body.String(), "log_monitor_cached_files 1\n")
+}
+
+// a result is served again until the file changes
+func TestResultCache(t *testing.T) {
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+	limits := defaultLimits
+	limits.resultTTL = time.Minute
+	router := newRouter(path_guard.NewResolver(dir, path_guard.ContainedSymlinks), limits)
+	get := func() string {
+		request, err := http.NewRequest("GET", "/syslog?lines=1", nil)
+		assert.Nil(t, err)
+		response := executeRequest(request, router)
+		assert.Equal(t, http.StatusOK, response.Code)
+		return response.Body.String()
+	}
+
+	assert.Equal(t, "def\n", get())
+	writer, err := os.OpenFile(filepath.Join(dir, "syslog"), os.O_APPEND|os.O_WRONLY, 0600)
+	assert.Nil(t, err)
+	defer writer.Close()
+	_, err = writer.WriteString("ghi\n")
+	assert.Nil(t, err)
+	assert.Equal(t, "ghi\n", get())
+	assert.Equal(t, "ghi\n", get())
+}
+
 func BenchmarkLargeFileRead_SingleRequest(b *testing.B) {
 	res, err := http.NewRequest("GET", "/syslog_large?lines=1000000", nil)
 	assert.Nil(b, err)
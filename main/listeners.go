@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const unixPrefix = "unix:"
+const systemdPrefix = "systemd"
+
+// the first file descriptor passed by systemd socket activation, after stdin, stdout and stderr
+const listenFDsStart = 3
+
+// listens on each address: host:port for tcp, unix:PATH for a unix socket with socketMode permissions,
+// or systemd (systemd:NAME for those with FileDescriptorName=NAME) for the sockets systemd passed in
+func listen(addresses []string, socketMode os.FileMode) ([]net.Listener, error) {
+	var listeners []net.Listener
+	var activated []namedListener
+	used := make(map[net.Listener]bool)
+	closeUnused := func() {
+		for _, passed := range activated {
+			if !used[passed.listener] {
+				passed.listener.Close()
+			}
+		}
+	}
+
+	for _, address := range addresses {
+		var listening []net.Listener
+		var err error
+		switch {
+		case strings.HasPrefix(address, unixPrefix):
+			var listener net.Listener
+			if listener, err = listenUnix(strings.TrimPrefix(address, unixPrefix), socketMode); err == nil {
+				listening = []net.Listener{listener}
+			}
+		case address == systemdPrefix || strings.HasPrefix(address, systemdPrefix+":"):
+			if activated == nil {
+				activated, err = systemdListeners(listenFDsStart)
+			}
+			if err == nil {
+				listening, err = matchListeners(activated, strings.TrimPrefix(strings.TrimPrefix(address, systemdPrefix), ":"))
+			}
+		default:
+			var listener net.Listener
+			if listener, err = net.Listen("tcp", address); err == nil {
+				listening = []net.Listener{listener}
+			}
+		}
+		if err != nil {
+			for _, listener := range listeners {
+				listener.Close()
+			}
+			closeUnused()
+			return nil, err
+		}
+		for _, listener := range listening {
+			used[listener] = true
+		}
+		listeners = append(listeners, listening...)
+	}
+	// passed in, but not asked for
+	closeUnused()
+	return listeners, nil
+}
+
+func validListener(address string) bool {
+	if strings.HasPrefix(address, unixPrefix) {
+		return len(address) > len(unixPrefix)
+	}
+	return address != "" && address != systemdPrefix+":"
+}
+
+// a socket left behind by a server that didn't shut down cleanly is replaced; anything else there is an error.
+// the socket is bound in a directory only this process can get into, and linked to path once it has mode,
+// so it is never there with the permissions the umask gave it
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, errors.New(path + " is in use")
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	dir, err := ioutil.TempDir(filepath.Dir(path), ".log_monitor")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	bound := filepath.Join(dir, "socket")
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: bound, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	listener.SetUnlinkOnClose(false)
+	err = os.Chmod(bound, mode)
+	if err == nil {
+		// unlike a rename, fails if anything is there
+		err = os.Link(bound, path)
+	}
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return &unixListener{UnixListener: listener, path: path}, nil
+}
+
+// removes the socket once closed
+type unixListener struct {
+	*net.UnixListener
+	path string
+}
+
+func (l *unixListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: l.path, Net: "unix"}
+}
+
+func (l *unixListener) Close() error {
+	err := l.UnixListener.Close()
+	if removeErr := os.Remove(l.path); err == nil && !os.IsNotExist(removeErr) {
+		err = removeErr
+	}
+	return err
+}
+
+type namedListener struct {
+	name     string
+	listener net.Listener
+}
+
+// the sockets passed by systemd (LISTEN_PID, LISTEN_FDS, LISTEN_FDNAMES), starting at firstFD; the
+// variables are unset so that they aren't inherited
+func systemdListeners(firstFD int) ([]namedListener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, errors.New("no sockets passed by systemd for this process")
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 1 {
+		return nil, errors.New("no sockets passed by systemd for this process")
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	listeners := make([]namedListener, 0, count)
+	for i := 0; i < count; i++ {
+		name := "unknown"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		file := os.NewFile(uintptr(firstFD+i), name)
+		// a duplicate, which is close on exec
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			for _, activated := range listeners {
+				activated.listener.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, namedListener{name: name, listener: listener})
+	}
+	return listeners, nil
+}
+
+// all of them if name is empty
+func matchListeners(activated []namedListener, name string) ([]net.Listener, error) {
+	var matched []net.Listener
+	for _, listener := range activated {
+		if name == "" || listener.name == name {
+			matched = append(matched, listener.listener)
+		}
+	}
+	if len(matched) == 0 {
+		return nil, errors.New("no socket named " + name + " passed by systemd")
+	}
+	return matched, nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"log_monitor/monitor/path_guard"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func unixClient(path string) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", path)
+		},
+	}}
+}
+
+func getBody(t *testing.T, client *http.Client, url string) string {
+	res, err := client.Get(url)
+	if !assert.Nil(t, err) {
+		return ""
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	assert.Nil(t, err)
+	return string(body)
+}
+
+func TestListenUnix(t *testing.T) {
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+	socket := filepath.Join(dir, "log_monitor.sock")
+
+	listeners, err := listen([]string{unixPrefix + socket, "127.0.0.1:0"}, 0600)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(listeners))
+	info, err := os.Stat(socket)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	// bound elsewhere, which is gone
+	bound, err := filepath.Glob(filepath.Join(dir, ".log_monitor*"))
+	assert.Nil(t, err)
+	assert.Empty(t, bound)
+
+	// in use
+	_, err = listen([]string{unixPrefix + socket}, 0600)
+	assert.NotNil(t, err)
+
+	server := CreateLogServer(dir, path_guard.ContainedSymlinks, defaultLimits, "", 100, 10000)
+	done := make(chan error, 1)
+	go func() {
+		done <- serveUntilSignalled(server, listeners, false, func() {}, time.Second)
+	}()
+	assert.Equal(t, "def\n", getBody(t, unixClient(socket), "http://log_monitor/v1/files/syslog?lines=1"))
+	assert.Equal(t, "def\n", getBody(t, http.DefaultClient, "http://"+listeners[1].Addr().String()+"/v1/files/syslog?lines=1"))
+
+	signalSelf(t, syscall.SIGTERM)
+	assert.Nil(t, <-done)
+	// cleaned up on close
+	_, err = os.Stat(socket)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestListenUnix_Stale(t *testing.T) {
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+	socket := filepath.Join(dir, "log_monitor.sock")
+
+	// left behind by a server that was killed
+	stale, err := net.Listen("unix", socket)
+	assert.Nil(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	listener, err := listenUnix(socket, 0660)
+	assert.Nil(t, err)
+	listener.Close()
+
+	// anything else is left alone
+	assert.Nil(t, ioutil.WriteFile(socket, nil, 0600))
+	_, err = listenUnix(socket, 0660)
+	assert.NotNil(t, err)
+	info, err := os.Lstat(socket)
+	assert.Nil(t, err)
+	assert.True(t, info.Mode().IsRegular())
+}
+
+// run by TestUnixPeers, as a client from another process
+func TestUnixPeers_Client(t *testing.T) {
+	socket := os.Getenv("LOG_MONITOR_TEST_SOCKET")
+	if socket == "" {
+		t.Skip("run by TestUnixPeers")
+	}
+	res, err := unixClient(socket).Get("http://log_monitor/v1/files/syslog?lines=1")
+	if assert.Nil(t, err) {
+		res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	}
+}
+
+// anonymous clients on a unix socket have a bucket per process
+func TestUnixPeers(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials are only read on linux")
+	}
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+	socket := filepath.Join(dir, "log_monitor.sock")
+	listeners, err := listen([]string{unixPrefix + socket}, 0600)
+	assert.Nil(t, err)
+	limits := defaultLimits
+	limits.rate = 0.001
+	limits.burst = 1
+	server := CreateLogServer(dir, path_guard.ContainedSymlinks, limits, "", 100, 10000)
+	done := make(chan error, 1)
+	go func() {
+		done <- serveUntilSignalled(server, listeners, false, func() {}, time.Second)
+	}()
+
+	status := func() int {
+		res, err := unixClient(socket).Get("http://log_monitor/v1/files/syslog?lines=1")
+		if !assert.Nil(t, err) {
+			return 0
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+	assert.Equal(t, http.StatusOK, status())
+	assert.Equal(t, http.StatusTooManyRequests, status())
+
+	client := exec.Command(os.Args[0], "-test.run=^TestUnixPeers_Client$")
+	client.Env = append(os.Environ(), "LOG_MONITOR_TEST_SOCKET="+socket)
+	output, err := client.CombinedOutput()
+	assert.Nil(t, err, string(output))
+
+	signalSelf(t, syscall.SIGTERM)
+	assert.Nil(t, <-done)
+}
+
+func TestSystemdListeners(t *testing.T) {
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+
+	// what systemd would pass: sockets at consecutive file descriptors, well clear of those in use
+	const first = 200
+	for i := 0; i < 2; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.Nil(t, err)
+		file, err := listener.(*net.TCPListener).File()
+		assert.Nil(t, err)
+		assert.Nil(t, syscall.Dup2(int(file.Fd()), first+i))
+		file.Close()
+		listener.Close()
+	}
+
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	os.Setenv("LISTEN_FDS", "2")
+	os.Setenv("LISTEN_FDNAMES", "web:agents")
+	activated, err := systemdListeners(first)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(activated))
+	// not passed on
+	assert.Equal(t, "", os.Getenv("LISTEN_FDS"))
+
+	agents, err := matchListeners(activated, "agents")
+	assert.Nil(t, err)
+	assert.Equal(t, []net.Listener{activated[1].listener}, agents)
+	_, err = matchListeners(activated, "metrics")
+	assert.NotNil(t, err)
+	all, err := matchListeners(activated, "")
+	assert.Nil(t, err)
+
+	server := CreateLogServer(dir, path_guard.ContainedSymlinks, defaultLimits, "", 100, 10000)
+	done := make(chan error, 1)
+	go func() {
+		done <- serveUntilSignalled(server, all, false, func() {}, time.Second)
+	}()
+	for _, listener := range all {
+		assert.Equal(t, "def\n", getBody(t, http.DefaultClient, "http://"+listener.Addr().String()+"/v1/files/syslog?lines=1"))
+	}
+	signalSelf(t, syscall.SIGTERM)
+	assert.Nil(t, <-done)
+
+	// for another process
+	os.Setenv("LISTEN_PID", "1")
+	os.Setenv("LISTEN_FDS", "1")
+	_, err = systemdListeners(first)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"io"
+	"log_monitor/monitor/chunk_reader"
+	"log_monitor/monitor/core_utils"
+	"log_monitor/monitor/file_reader"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// upper bounds of the request latency histogram buckets, in seconds
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// requests served by the routers loaded, by query (the route's name) and status; exposed in the prometheus
+// text format along with chunk_reader.ReadStats
+type requestMetrics struct {
+	mutex    sync.Mutex
+	requests map[requestLabels]*histogram
+	files    *file_reader.FileCache // the current router's, if set
+}
+
+type requestLabels struct {
+	query  string
+	status int
+}
+
+type histogram struct {
+	buckets []uint64 // per latencyBuckets, not cumulative
+	count   uint64
+	sum     float64
+}
+
+func newRequestMetrics() *requestMetrics {
+	return &requestMetrics{requests: make(map[requestLabels]*histogram)}
+}
+
+func (m *requestMetrics) setFiles(files *file_reader.FileCache) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.files = files
+}
+
+func (m *requestMetrics) observe(labels requestLabels, seconds float64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	h, ok := m.requests[labels]
+	if !ok {
+		h = &histogram{buckets: make([]uint64, len(latencyBuckets))}
+		m.requests[labels] = h
+	}
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.buckets[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += int64(n)
+	return n, err
+}
+
+// middleware; routes are told apart by name
+func (m *requestMetrics) measure(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		query := "other"
+		if route := mux.CurrentRoute(r); route != nil && route.GetName() != "" {
+			query = route.GetName()
+		}
+		m.observe(requestLabels{query: query, status: recorder.status}, time.Since(start).Seconds())
+	})
+}
+
+func (m *requestMetrics) serve(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.write(w)
+}
+
+func (m *requestMetrics) write(w io.Writer) {
+	m.mutex.Lock()
+	labels := make([]requestLabels, 0, len(m.requests))
+	requests := make(map[requestLabels]histogram, len(m.requests))
+	for l, h := range m.requests {
+		labels = append(labels, l)
+		requests[l] = histogram{buckets: append([]uint64(nil), h.buckets...), count: h.count, sum: h.sum}
+	}
+	files := m.files
+	m.mutex.Unlock()
+	sort.Slice(labels, func(i, j int) bool {
+		if labels[i].query != labels[j].query {
+			return labels[i].query < labels[j].query
+		}
+		return labels[i].status < labels[j].status
+	})
+
+	writeHeader(w, "log_monitor_requests_total", "counter", "Requests served, by query and status.")
+	for _, l := range labels {
+		fmt.Fprintf(w, "log_monitor_requests_total{%s} %d\n", l, requests[l].count)
+	}
+
+	writeHeader(w, "log_monitor_request_duration_seconds", "histogram", "Time taken to serve requests, by query and status.")
+	for _, l := range labels {
+		h := requests[l]
+		cumulative := uint64(0)
+		for i, bound := range latencyBuckets {
+			cumulative += h.buckets[i]
+			fmt.Fprintf(w, "log_monitor_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", l, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(w, "log_monitor_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", l, h.count)
+		fmt.Fprintf(w, "log_monitor_request_duration_seconds_sum{%s} %s\n", l, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(w, "log_monitor_request_duration_seconds_count{%s} %d\n", l, h.count)
+	}
+
+	stats := chunk_reader.ReadStats()
+	writeMetric(w, "log_monitor_read_bytes_total", "counter", "Bytes read from disk by ChunkRead.", stats.BytesRead)
+	writeMetric(w, "log_monitor_chunks_processed_total", "counter", "Chunks read and processed.", stats.Chunks)
+	writeMetric(w, "log_monitor_parse_goroutines", "gauge", "Parse workers parsing blocks right now.", stats.Parsing)
+	writeMetric(w, "log_monitor_parse_queued", "gauge", "Blocks waiting for a parse worker.", stats.Queued)
+	writeMetric(w, "log_monitor_truncations_detected_total", "counter", "Reads failed on a file shrinking underneath them.", stats.Truncations)
+	pooled, pooledBytes := core_utils.PooledBuffers()
+	writeMetric(w, "log_monitor_pooled_buffers", "gauge", "Free buffers kept for reuse.", int64(pooled))
+	writeMetric(w, "log_monitor_pooled_buffer_bytes", "gauge", "Bytes taken by free buffers kept for reuse.", pooledBytes)
+	if files != nil {
+		writeMetric(w, "log_monitor_cached_files", "gauge", "Files kept open for the reads after.", int64(files.Count()))
+	}
+	writeMetric(w, "log_monitor_goroutines", "gauge", "Goroutines in the process.", int64(runtime.NumGoroutine()))
+	if fds, err := openFileDescriptors(); err == nil {
+		writeMetric(w, "process_open_fds", "gauge", "Open file descriptors.", fds)
+	}
+}
+
+func (l requestLabels) String() string {
+	return fmt.Sprintf("query=%q,status=\"%d\"", l.query, l.status)
+}
+
+func writeHeader(w io.Writer, name string, kind string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeMetric(w io.Writer, name string, kind string, help string, value int64) {
+	writeHeader(w, name, kind, help)
+	fmt.Fprintf(w, "%s %d\n", name, value)
+}
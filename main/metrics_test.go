@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+	router := getRouter(dir)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		return executeRequest(httptest.NewRequest("GET", path, nil), router)
+	}
+	get("/syslog?lines=1")
+	get("/syslog?lines=1")
+	get("/v1/files/nginx/access.log?filter=GET")
+	get("/missing?lines=1")
+	get("/v1/files/")
+
+	response := get("/metrics")
+	assert.Equal(t, 200, response.Code)
+	assert.Equal(t, "text/plain; version=0.0.4", response.Header().Get("Content-Type"))
+	body := response.Body.String()
+	for _, line := range []string{
+		"# TYPE log_monitor_requests_total counter",
+		`log_monitor_requests_total{query="lines",status="200"} 2`,
+		`log_monitor_requests_total{query="lines",status="404"} 1`,
+		`log_monitor_requests_total{query="filter",status="200"} 1`,
+		`log_monitor_requests_total{query="list",status="200"} 1`,
+		"# TYPE log_monitor_request_duration_seconds histogram",
+		`log_monitor_request_duration_seconds_bucket{query="lines",status="200",le="+Inf"} 2`,
+		`log_monitor_request_duration_seconds_count{query="lines",status="200"} 2`,
+		"# TYPE log_monitor_read_bytes_total counter",
+		"# TYPE log_monitor_chunks_processed_total counter",
+		"# TYPE log_monitor_pooled_buffers gauge",
+		"# TYPE log_monitor_parse_goroutines gauge",
+		"# TYPE log_monitor_parse_queued gauge",
+		"# TYPE log_monitor_truncations_detected_total counter",
+		"# TYPE log_monitor_cached_files gauge",
+		"# TYPE process_open_fds gauge",
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+	assert.NotContains(t, body, "log_monitor_read_bytes_total 0\n")
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	metrics := newRequestMetrics()
+	labels := requestLabels{query: "lines", status: 200}
+	metrics.observe(labels, 0.001)
+	metrics.observe(labels, 0.3)
+	metrics.observe(labels, 60)
+
+	var buf bytes.Buffer
+	metrics.write(&buf)
+	body := buf.String()
+	assert.Contains(t, body, `log_monitor_request_duration_seconds_bucket{query="lines",status="200",le="0.005"} 1`+"\n")
+	assert.Contains(t, body, `log_monitor_request_duration_seconds_bucket{query="lines",status="200",le="0.25"} 1`+"\n")
+	assert.Contains(t, body, `log_monitor_request_duration_seconds_bucket{query="lines",status="200",le="0.5"} 2`+"\n")
+	assert.Contains(t, body, `log_monitor_request_duration_seconds_bucket{query="lines",status="200",le="10"} 2`+"\n")
+	assert.Contains(t, body, `log_monitor_request_duration_seconds_bucket{query="lines",status="200",le="+Inf"} 3`+"\n")
+	assert.Contains(t, body, `log_monitor_request_duration_seconds_sum{query="lines",status="200"} 60.301`+"\n")
+	assert.True(t, strings.HasPrefix(body, "# HELP log_monitor_requests_total"))
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"syscall"
+)
+
+// the uid and pid of the process at the other end of a unix socket
+func peerCredentials(conn net.Conn) (string, bool) {
+	unix, ok := conn.(*net.UnixConn)
+	if !ok {
+		return "", false
+	}
+	raw, err := unix.SyscallConn()
+	if err != nil {
+		return "", false
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil || credErr != nil {
+		return "", false
+	}
+	return strconv.FormatUint(uint64(cred.Uid), 10) + "/" + strconv.FormatInt(int64(cred.Pid), 10), true
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"path"
+	"strings"
+)
+
+// who may read the files whose path (relative to the served directory) matches Pattern, as in path.Match;
+// a pattern matching a directory covers everything under it, so "private/*" covers private/a/b.log. The
+// first policy matching a path applies, and files no policy matches can be read by anyone
+type filePolicy struct {
+	Pattern string `json:"pattern"`
+	// principals (see withPrincipal) allowed; missing is anyone, empty is no one. "" is the anonymous principal
+	Principals *[]string `json:"principals"`
+	// reads of the files, and attempts at them, go to the audit log
+	Audit bool `json:"audit"`
+}
+
+func (p filePolicy) allows(principal string) bool {
+	if p.Principals == nil {
+		return true
+	}
+	for _, allowed := range *p.Principals {
+		if allowed == principal {
+			return true
+		}
+	}
+	return false
+}
+
+// the policy that applies to a file, nil if none does
+func matchPolicy(policies []filePolicy, relative string) *filePolicy {
+	for i := range policies {
+		if matchesOrCovers(policies[i].Pattern, relative) {
+			return &policies[i]
+		}
+	}
+	return nil
+}
+
+// whether pattern matches relative or one of the directories it is under
+func matchesOrCovers(pattern string, relative string) bool {
+	for end := 0; end < len(relative); end++ {
+		if relative[end] != '/' {
+			continue
+		}
+		if matched, _ := path.Match(pattern, relative[:end]); matched {
+			return true
+		}
+	}
+	matched, _ := path.Match(pattern, relative)
+	return matched
+}
+
+func mayRead(policies []filePolicy, relative string, principal string) bool {
+	policy := matchPolicy(policies, relative)
+	return policy == nil || policy.allows(principal)
+}
+
+// a file is read by the path it's asked for and the one it resolves to (following symlinks, see
+// path_guard.Resolver.Resolve); both have to be allowed, so a symlink isn't a way around a policy
+func mayReadResolved(policies []filePolicy, relative string, resolved string, principal string) bool {
+	return mayRead(policies, relative, principal) && mayRead(policies, resolved, principal)
+}
+
+// drops the files a principal may not read from a listing, by their path and the one resolve gives;
+// directories stay
+func filterListing(policies []filePolicy, entries []string, principal string, resolve func(string) (string, error)) []string {
+	if len(policies) == 0 {
+		return entries
+	}
+	allowed := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if strings.HasSuffix(entry, "/") {
+			allowed = append(allowed, entry)
+			continue
+		}
+		resolved, err := resolve(entry)
+		if err == nil && mayReadResolved(policies, entry, resolved, principal) {
+			allowed = append(allowed, entry)
+		}
+	}
+	return allowed
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"log_monitor/monitor/path_guard"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func principals(names ...string) *[]string {
+	return &names
+}
+
+// resolves to the path as given
+func identity(relative string) (string, error) {
+	return relative, nil
+}
+
+func TestMayRead(t *testing.T) {
+	policies := []filePolicy{
+		{Pattern: "nginx/secret.log", Principals: principals()},
+		{Pattern: "nginx/*", Principals: principals("ops", "web")},
+		{Pattern: "auth.log", Principals: principals("ops")},
+		{Pattern: "*.log"},
+	}
+
+	assert.True(t, mayRead(policies, "nginx/access.log", "web"))
+	assert.False(t, mayRead(policies, "nginx/access.log", anonymousPrincipal))
+	// the first match applies
+	assert.False(t, mayRead(policies, "nginx/secret.log", "ops"))
+	assert.False(t, mayRead(policies, "auth.log", "web"))
+	assert.True(t, mayRead(policies, "kern.log", anonymousPrincipal))
+	// no match
+	assert.True(t, mayRead(policies, "syslog", anonymousPrincipal))
+	assert.True(t, mayRead(nil, "auth.log", anonymousPrincipal))
+	// a pattern matching a directory covers what's under it
+	assert.False(t, mayRead(policies, "nginx/old/access.log", anonymousPrincipal))
+	assert.True(t, mayRead(policies, "nginx/old/access.log", "web"))
+	assert.False(t, mayReadResolved(policies, "public.log", "nginx/access.log", anonymousPrincipal))
+
+	assert.Equal(t, []string{"nginx/", "syslog"}, filterListing(policies, []string{"nginx/", "nginx/access.log", "syslog"}, anonymousPrincipal, identity))
+}
+
+func TestFilePolicies_Router(t *testing.T) {
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+	limits := defaultLimits
+	limits.policies = []filePolicy{{Pattern: "nginx/*", Principals: principals("ops")}}
+	router := newRouter(path_guard.NewResolver(dir, path_guard.ContainedSymlinks), limits)
+
+	get := func(path string, principal string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		if principal != anonymousPrincipal {
+			r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: principal}}}}
+		}
+		return executeRequest(r, router)
+	}
+
+	assert.Equal(t, http.StatusForbidden, get("/v1/files/nginx/access.log?lines=1", anonymousPrincipal).Code)
+	assert.Equal(t, http.StatusForbidden, get("/v1/files/nginx/access.log?filter=GET", "web").Code)
+	assert.Equal(t, "GET /index\n", get("/v1/files/nginx/access.log?lines=1", "ops").Body.String())
+	// escapes aren't a way around a policy
+	assert.Equal(t, http.StatusForbidden, get("/v1/files/nginx%2Faccess.log?lines=1", anonymousPrincipal).Code)
+	assert.Equal(t, "def\n", get("/v1/files/syslog?lines=1", anonymousPrincipal).Body.String())
+
+	assert.Equal(t, "", get("/v1/files/nginx/", anonymousPrincipal).Body.String())
+	assert.Equal(t, "nginx/access.log\n", get("/v1/files/nginx/", "ops").Body.String())
+}
+
+// a symlink to a file under a denied directory, or to the directory, doesn't get around the policy
+func TestFilePolicies_Symlinks(t *testing.T) {
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "private", "a"), 0700))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "private", "secret.log"), []byte("secret\n"), 0600))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "private", "a", "b.log"), []byte("nested\n"), 0600))
+	assert.Nil(t, os.Symlink("private/secret.log", filepath.Join(dir, "public.log")))
+	assert.Nil(t, os.Symlink("private", filepath.Join(dir, "pub")))
+
+	limits := defaultLimits
+	limits.policies = []filePolicy{{Pattern: "private/*", Principals: principals()}}
+	router := newRouter(path_guard.NewResolver(dir, path_guard.ContainedSymlinks), limits)
+	get := func(path string) *httptest.ResponseRecorder {
+		return executeRequest(httptest.NewRequest("GET", path, nil), router)
+	}
+
+	for _, path := range []string{"private/secret.log", "private/a/b.log", "public.log", "pub/secret.log", "pub/a/b.log"} {
+		response := get("/v1/files/" + path + "?lines=1")
+		assert.Equal(t, http.StatusForbidden, response.Code, path)
+		assert.NotContains(t, response.Body.String(), "secret", path)
+	}
+	assert.Equal(t, "def\n", get("/v1/files/syslog?lines=1").Body.String())
+
+	listing := get("/v1/files/?depth=3").Body.String()
+	for _, hidden := range []string{"private/secret.log\n", "private/a/b.log\n", "public.log\n"} {
+		assert.NotContains(t, listing, hidden)
+	}
+	assert.Contains(t, listing, "syslog\n")
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"net"
+	"net/http"
+)
+
+type principalKey struct{}
+
+type peerKey struct{}
+
+const anonymousPrincipal = ""
+
+// the principal is who a request is made on behalf of; access control works off of this
+func withPrincipal(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, requestPrincipal(r))))
+	})
+}
+
+func requestPrincipal(r *http.Request) string {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return certificatePrincipal(r.TLS.PeerCertificates[0])
+	}
+	return anonymousPrincipal
+}
+
+func getPrincipal(r *http.Request) string {
+	if principal, ok := r.Context().Value(principalKey{}).(string); ok {
+		return principal
+	}
+	return anonymousPrincipal
+}
+
+func certificatePrincipal(cert *x509.Certificate) string {
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+	return cert.Subject.String()
+}
+
+// set as the server's ConnContext: on a unix socket, anonymous clients have no address to be told apart
+// by, so the process connecting is (see peerCredentials)
+func withPeer(ctx context.Context, conn net.Conn) context.Context {
+	// under tls
+	if wrapped, ok := conn.(interface{ NetConn() net.Conn }); ok {
+		conn = wrapped.NetConn()
+	}
+	if peer, ok := peerCredentials(conn); ok {
+		return context.WithValue(ctx, peerKey{}, peer)
+	}
+	return ctx
+}
+
+func getPeer(r *http.Request) (string, bool) {
+	peer, ok := r.Context().Value(peerKey{}).(string)
+	return peer, ok
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"github.com/gorilla/mux"
+	"log_monitor/monitor/path_guard"
+	"net/http"
+	"sync/atomic"
+)
+
+// serves with whichever router was loaded last; a request keeps the router it started with,
+// so one in flight during a reload finishes with the old configuration
+type reloadableHandler struct {
+	status *serverStatus
+	logs   *requestLogs // kept across reloads; nil is no logging
+	router atomic.Value // *mux.Router
+	limits queryLimits  // the last loaded, set up; loads don't run at once
+}
+
+func (h *reloadableHandler) load(resolver path_guard.Resolver, limits queryLimits, roots ...namedRoot) {
+	limits = carryOver(h.limits, limits).setUp()
+	h.limits = limits
+	router := newRouter(resolver, limits, roots...)
+	addStatusRoutes(router, h.status, resolver.Root(), roots...)
+	h.router.Store(router)
+}
+
+func (h *reloadableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var handler http.Handler = h.router.Load().(*mux.Router)
+	if h.logs != nil {
+		handler = h.logs.log(handler)
+	}
+	handler.ServeHTTP(w, r)
+}
+
+// next with the state shared by previous's requests, so a reload doesn't reset the metrics, refill the
+// clients' buckets or run reads on both routers past the limits. What was set up from settings that
+// changed is set up anew
+func carryOver(previous queryLimits, next queryLimits) queryLimits {
+	next.metrics = previous.metrics
+	if previous.rate == next.rate && previous.burst == next.burst && previous.maxConcurrentReads == next.maxConcurrentReads && previous.queueTimeout == next.queueTimeout {
+		next.admission = previous.admission
+	}
+	if previous.globalReadRate == next.globalReadRate {
+		next.globalThrottle = previous.globalThrottle
+	}
+	if previous.cachedFiles == next.cachedFiles {
+		next.files = previous.files
+	}
+	if previous.resultTTL == next.resultTTL && previous.resultCacheSize == next.resultCacheSize {
+		next.coalescer = previous.coalescer
+	}
+	return next
+}
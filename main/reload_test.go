@@ -0,0 +1,98 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"log_monitor/monitor/path_guard"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReloadableHandler(t *testing.T) {
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+	other, otherCleanup := createLogTree(t)
+	defer otherCleanup()
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(other, "syslog"), []byte("ghi\n"), 0600))
+
+	server, handler := newLogServer("", 0, 0)
+	assert.Equal(t, handler, server.Handler)
+	handler.load(path_guard.NewResolver(dir, path_guard.ContainedSymlinks), defaultLimits)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+		return recorder
+	}
+	assert.Equal(t, "def\n", get("/v1/files/syslog?lines=1").Body.String())
+
+	limits := defaultLimits
+	limits.policies = []filePolicy{{Pattern: "syslog", Principals: principals()}}
+	handler.load(path_guard.NewResolver(other, path_guard.ContainedSymlinks), limits)
+	assert.Equal(t, http.StatusForbidden, get("/v1/files/syslog?lines=1").Code)
+	assert.Equal(t, "start\n", get("/v1/files/installer/subiquity/server.log?lines=1").Body.String())
+	assert.Contains(t, get("/version").Body.String(), `"dir":"`+other+`"`)
+}
+
+func TestReloadableHandler_InFlight(t *testing.T) {
+	dir, cleanup := createSlowFile(t)
+	defer cleanup()
+	limits := defaultLimits
+	limits.readRate = 1 << 20
+
+	server, handler := newLogServer("", 0, 0)
+	handler.load(path_guard.NewResolver(dir, path_guard.ContainedSymlinks), limits)
+	recorder := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		server.Handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/v1/files/big.log?filter=0123", nil))
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// the read in flight keeps the router, and the directory, it started with
+	handler.load(path_guard.NewResolver(filepath.Join(dir, "nginx"), path_guard.ContainedSymlinks), limits)
+	<-done
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, 16000*17, recorder.Body.Len())
+}
+
+// a reload keeps the metrics, the clients' buckets and the reads running, unless their limits change
+func TestReloadableHandler_Carried(t *testing.T) {
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+	limits := defaultLimits
+	limits.rate = 0.001
+	limits.burst = 1
+	limits.maxConcurrentReads = 4
+	limits.queueTimeout = time.Second
+
+	_, handler := newLogServer("", 0, 0)
+	handler.load(path_guard.NewResolver(dir, path_guard.ContainedSymlinks), limits)
+	get := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+		return recorder
+	}
+	assert.Equal(t, http.StatusOK, get("/v1/files/syslog?lines=1").Code)
+	loaded := handler.limits
+
+	limits.policies = []filePolicy{{Pattern: "nginx/*"}}
+	handler.load(path_guard.NewResolver(dir, path_guard.ContainedSymlinks), limits)
+	assert.Equal(t, http.StatusTooManyRequests, get("/v1/files/syslog?lines=1").Code)
+	assert.Contains(t, get("/metrics").Body.String(), `log_monitor_requests_total{query="lines",status="200"} 1`)
+	assert.Same(t, loaded.admission, handler.limits.admission)
+	assert.Same(t, loaded.files, handler.limits.files)
+	assert.Same(t, loaded.coalescer, handler.limits.coalescer)
+
+	limits.maxConcurrentReads = 8
+	limits.cachedFiles = 8
+	handler.load(path_guard.NewResolver(dir, path_guard.ContainedSymlinks), limits)
+	assert.NotSame(t, loaded.admission, handler.limits.admission)
+	assert.NotSame(t, loaded.files, handler.limits.files)
+	assert.Same(t, loaded.metrics, handler.limits.metrics)
+	assert.Equal(t, http.StatusOK, get("/v1/files/syslog?lines=1").Code)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"io"
+	"log_monitor/monitor/path_guard"
+	"net/http"
+)
+
+// a further directory served under /v1/roots/{name}/files, with its own read settings and policies
+type namedRoot struct {
+	name          string
+	resolver      path_guard.Resolver
+	chunkSize     int64
+	maxLineLength int
+	policies      []filePolicy
+	defaults      queryDefaults
+}
+
+// the root's settings, with the rest shared with every other root
+func (root namedRoot) limits(shared queryLimits) queryLimits {
+	shared.chunkSize = root.chunkSize
+	shared.maxLineLength = root.maxLineLength
+	shared.policies = root.policies
+	shared.defaults = root.defaults
+	return shared
+}
+
+// the names, one per line
+func serveRoots(roots []namedRoot) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, root := range roots {
+			io.WriteString(w, root.name+"\n")
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"log_monitor/monitor/path_guard"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNamedRoots(t *testing.T) {
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+	app, err := ioutil.TempDir("", "roots")
+	assert.Nil(t, err)
+	defer os.RemoveAll(app)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(app, "app.log"), []byte("2020 a\n  at b\n2021 c\n  at d\n"), 0600))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(app, "print0"), []byte("x\x00y\x00"), 0600))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(app, "secret.log"), []byte("s\n"), 0600))
+
+	roots := []namedRoot{
+		{name: "system", resolver: path_guard.NewResolver(dir, path_guard.ContainedSymlinks)},
+		{
+			name:          "app",
+			resolver:      path_guard.NewResolver(app, path_guard.ContainedSymlinks),
+			maxLineLength: 64,
+			policies:      []filePolicy{{Pattern: "secret.log", Principals: principals()}},
+			defaults:      queryDefaults{recordStart: `^\d{4} `},
+		},
+	}
+	router := newRouter(path_guard.NewResolver(dir, path_guard.ContainedSymlinks), defaultLimits, roots...)
+	get := func(path string) *httptest.ResponseRecorder {
+		return executeRequest(httptest.NewRequest("GET", path, nil), router)
+	}
+
+	assert.Equal(t, "system\napp\n", get("/v1/roots").Body.String())
+	assert.Equal(t, "def\n", get("/v1/roots/system/files/syslog?lines=1").Body.String())
+	assert.Equal(t, "def\n", get("/v1/files/syslog?lines=1").Body.String())
+	assert.Equal(t, "nginx/access.log\n", get("/v1/roots/system/files/nginx/").Body.String())
+
+	// the root's defaults, which the query can still override
+	assert.Equal(t, "2021 c\n  at d\n", get("/v1/roots/app/files/app.log?lines=1").Body.String())
+	assert.Equal(t, "  at d\n", get("/v1/roots/app/files/app.log?lines=1&record_start=").Body.String())
+	assert.Equal(t, "y\x00", get("/v1/roots/app/files/print0?lines=1&delimiter=nul&record_start=").Body.String())
+
+	// its own policies, and files only from its own directory
+	assert.Equal(t, http.StatusForbidden, get("/v1/roots/app/files/secret.log?lines=1").Code)
+	assert.Equal(t, http.StatusForbidden, get("/v1/roots/app/files/../syslog?lines=1").Code)
+	assert.Equal(t, http.StatusNotFound, get("/v1/roots/app/files/syslog?lines=1").Code)
+	assert.Equal(t, "app.log\nprint0\n", get("/v1/roots/app/files/").Body.String())
+	assert.Equal(t, http.StatusNotFound, get("/v1/roots/k8s/files/syslog?lines=1").Code)
+}
+
+// roots over the same directory don't get each other's results
+func TestNamedRoots_ResultCache(t *testing.T) {
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "fields"), []byte("a,b\nc,d\n"), 0600))
+	limits := defaultLimits
+	limits.resultTTL = time.Minute
+	resolver := path_guard.NewResolver(dir, path_guard.ContainedSymlinks)
+	router := newRouter(resolver, limits,
+		namedRoot{name: "lines", resolver: resolver, maxLineLength: limits.maxLineLength},
+		namedRoot{name: "fields", resolver: resolver, maxLineLength: limits.maxLineLength, defaults: queryDefaults{delimiter: ","}})
+	get := func(path string) string {
+		return executeRequest(httptest.NewRequest("GET", path, nil), router).Body.String()
+	}
+
+	assert.Equal(t, "c,d\n", get("/v1/roots/lines/files/fields?lines=1"))
+	assert.Equal(t, "b\nc,", get("/v1/roots/fields/files/fields?lines=1"))
+	assert.Equal(t, "c,d\n", get("/v1/files/fields?lines=1"))
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// serves on every listener until SIGTERM or SIGINT, then shuts down gracefully: no new connections are accepted,
+// readiness goes false (see CreateLogServer) and requests in flight get drainTimeout to finish before their
+// reads are canceled. SIGHUP calls reload
+func serveUntilSignalled(server *http.Server, listeners []net.Listener, useTLS bool, reload func(), drainTimeout time.Duration) error {
+	// every request's context comes from this one, so canceling it cancels their reads
+	base, cancelReads := context.WithCancel(context.Background())
+	defer cancelReads()
+	server.BaseContext = func(net.Listener) context.Context { return base }
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	served := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func(listener net.Listener) {
+			if useTLS {
+				served <- server.ServeTLS(listener, "", "")
+			} else {
+				served <- server.Serve(listener)
+			}
+		}(listener)
+	}
+
+	for {
+		select {
+		case err := <-served:
+			// one listener failing takes the others down with it
+			server.Close()
+			return err
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				reload()
+				continue
+			}
+			log.Println(sig, "received, shutting down")
+			return shutdown(server, served, len(listeners), cancelReads, drainTimeout)
+		}
+	}
+}
+
+func shutdown(server *http.Server, served <-chan error, serving int, cancelReads func(), drainTimeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+
+	err := server.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Println("requests still in flight after the drain timeout, canceling them")
+		cancelReads()
+		err = server.Close()
+	}
+	// Serve returns ErrServerClosed as soon as shutdown starts
+	for i := 0; i < serving; i++ {
+		if serveErr := <-served; !errors.Is(serveErr, http.ErrServerClosed) && err == nil {
+			err = serveErr
+		}
+	}
+	return err
+}
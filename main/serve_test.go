@@ -0,0 +1,143 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"log_monitor/monitor/path_guard"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// serves dir on an ephemeral port; the returned channel gets what serveUntilSignalled returns
+func startServer(t *testing.T, dir string, limits queryLimits, reload func(), drainTimeout time.Duration) (string, chan error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	server := CreateLogServer(dir, path_guard.ContainedSymlinks, limits, listener.Addr().String(), 100, 10000)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- serveUntilSignalled(server, []net.Listener{listener}, false, reload, drainTimeout)
+	}()
+
+	url := "http://" + listener.Addr().String()
+	for i := 0; ; i++ {
+		res, err := http.Get(url + "/healthz")
+		if err == nil {
+			res.Body.Close()
+			break
+		}
+		assert.True(t, i < 100, "server didn't start")
+		time.Sleep(10 * time.Millisecond)
+	}
+	return url, done
+}
+
+func signalSelf(t *testing.T, sig syscall.Signal) {
+	assert.Nil(t, syscall.Kill(syscall.Getpid(), sig))
+}
+
+func createSlowFile(t *testing.T) (string, func()) {
+	dir, cleanup := createLogTree(t)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "big.log"), []byte(strings.Repeat("0123456789abcdef\n", 16000)), 0600))
+	return dir, cleanup
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	dir, cleanup := createSlowFile(t)
+	defer cleanup()
+	// 272000 bytes take about 400ms
+	url, done := startServer(t, dir, queryLimits{readRate: 500000}, func() {}, 5*time.Second)
+
+	type result struct {
+		status int
+		body   string
+		err    error
+	}
+	inFlight := make(chan result, 1)
+	go func() {
+		res, err := http.Get(url + "/big.log?filter=f")
+		if err != nil {
+			inFlight <- result{err: err}
+			return
+		}
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		inFlight <- result{status: res.StatusCode, body: string(body), err: err}
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	signalSelf(t, syscall.SIGTERM)
+	select {
+	case err := <-done:
+		assert.Nil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server didn't shut down")
+	}
+
+	// the request in flight was let finish
+	res := <-inFlight
+	assert.Nil(t, res.err)
+	assert.Equal(t, http.StatusOK, res.status)
+	assert.Equal(t, 16000, strings.Count(res.body, "\n"))
+
+	// and nothing new is accepted
+	_, err := http.Get(url + "/healthz")
+	assert.NotNil(t, err)
+}
+
+func TestShutdown_DrainTimeout(t *testing.T) {
+	dir, cleanup := createSlowFile(t)
+	defer cleanup()
+	// would take minutes
+	url, done := startServer(t, dir, queryLimits{readRate: 1000}, func() {}, 100*time.Millisecond)
+
+	inFlight := make(chan error, 1)
+	go func() {
+		res, err := http.Get(url + "/big.log?filter=f")
+		if err == nil {
+			_, err = ioutil.ReadAll(res.Body)
+			res.Body.Close()
+		}
+		inFlight <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now()
+	signalSelf(t, syscall.SIGINT)
+	select {
+	case err := <-done:
+		assert.Nil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("reads weren't canceled")
+	}
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+	<-inFlight
+}
+
+func TestHangupReloads(t *testing.T) {
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+	reloaded := make(chan struct{}, 1)
+	url, done := startServer(t, dir, defaultLimits, func() { reloaded <- struct{}{} }, time.Second)
+
+	signalSelf(t, syscall.SIGHUP)
+	select {
+	case <-reloaded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not reloaded")
+	}
+
+	// still serving
+	res, err := http.Get(url + "/syslog?lines=1")
+	assert.Nil(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	signalSelf(t, syscall.SIGTERM)
+	assert.Nil(t, <-done)
+}
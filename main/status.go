@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// set at build time: go build -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse HEAD)"
+var (
+	version = "dev"
+	commit  = "unknown"
+)
+
+// not ready when fewer than this fraction of the file descriptor limit is left to open files with
+const minFreeFileDescriptors = 0.1
+
+// what the health, readiness and version endpoints report on, besides the served directory
+type serverStatus struct {
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	shuttingDown int32
+
+	mutex   sync.Mutex
+	devices map[string]uint64 // each served directory's, as first loaded
+}
+
+// called when the server starts shutting down; it isn't ready from then on
+func (s *serverStatus) shutdown() {
+	atomic.StoreInt32(&s.shuttingDown, 1)
+}
+
+func addStatusRoutes(router *mux.Router, status *serverStatus, dir string, roots ...namedRoot) {
+	status.rememberDevice(dir)
+	for _, root := range roots {
+		status.rememberDevice(root.resolver.Root())
+	}
+	router.HandleFunc("/healthz", serveHealth).Methods("GET")
+	router.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		status.serveReady(w, r, dir, roots...)
+	}).Methods("GET")
+	router.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
+		status.serveVersion(w, r, dir)
+	}).Methods("GET")
+}
+
+// the process is up and serving
+func serveHealth(w http.ResponseWriter, r *http.Request) {
+	io.WriteString(w, "ok\n")
+}
+
+// 503 with a line per reason when not ready
+func (s *serverStatus) serveReady(w http.ResponseWriter, r *http.Request, dir string, roots ...namedRoot) {
+	problems := s.notReady(dir, roots...)
+	if len(problems) > 0 {
+		http.Error(w, strings.Join(problems, "\n"), http.StatusServiceUnavailable)
+		return
+	}
+	io.WriteString(w, "ok\n")
+}
+
+// every root's directory has to be readable, not just the top level one
+func (s *serverStatus) notReady(dir string, roots ...namedRoot) []string {
+	var problems []string
+	if atomic.LoadInt32(&s.shuttingDown) != 0 {
+		problems = append(problems, "shutting down")
+	}
+	if err := s.checkDir(dir); err != nil {
+		problems = append(problems, "served directory: "+err.Error())
+	}
+	for _, root := range roots {
+		if err := s.checkDir(root.resolver.Root()); err != nil {
+			problems = append(problems, "root "+root.name+": "+err.Error())
+		}
+	}
+	if err := checkFileDescriptors(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	return problems
+}
+
+func (s *serverStatus) checkDir(dir string) error {
+	if err := checkReadable(dir); err != nil {
+		return err
+	}
+	return s.checkDevice(dir)
+}
+
+// a volume gone bad shows up as an error here
+func checkReadable(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Readdirnames(1); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// an unmounted volume leaves its mountpoint behind, empty and readable but on the device underneath, so a
+// directory's device is remembered when it is first loaded to be checked against. A volume that wasn't
+// mounted yet by then isn't caught
+func (s *serverStatus) rememberDevice(dir string) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return
+	}
+	id, ok := fileIdentity(info)
+	if !ok {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.devices == nil {
+		s.devices = make(map[string]uint64)
+	}
+	if _, seen := s.devices[dir]; !seen {
+		s.devices[dir] = id.dev
+	}
+}
+
+// skipped where devices can't be told apart, and for directories that couldn't be stat'ed when loaded
+func (s *serverStatus) checkDevice(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	id, ok := fileIdentity(info)
+	if !ok {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if device, seen := s.devices[dir]; seen && device != id.dev {
+		return errors.New("on another device than when it was loaded, its volume may be unmounted")
+	}
+	return nil
+}
+
+// skipped where descriptors can't be counted
+func checkFileDescriptors() error {
+	open, err := openFileDescriptors()
+	if err != nil {
+		return nil
+	}
+	limit, err := fileDescriptorLimit()
+	if err != nil || limit <= 0 {
+		return nil
+	}
+	return checkHeadroom(open, limit)
+}
+
+func checkHeadroom(open int64, limit int64) error {
+	if float64(limit-open) < float64(limit)*minFreeFileDescriptors {
+		return errors.New("file descriptors: " + strconv.FormatInt(open, 10) + " of " + strconv.FormatInt(limit, 10) + " open")
+	}
+	return nil
+}
+
+type versionInfo struct {
+	Version        string `json:"version"`
+	Commit         string `json:"commit"`
+	GoVersion      string `json:"go_version"`
+	Dir            string `json:"dir"`
+	ReadTimeoutMs  int64  `json:"read_timeout_ms"`
+	WriteTimeoutMs int64  `json:"write_timeout_ms"`
+}
+
+func (s *serverStatus) serveVersion(w http.ResponseWriter, r *http.Request, dir string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versionInfo{
+		Version:        version,
+		Commit:         commit,
+		GoVersion:      runtime.Version(),
+		Dir:            dir,
+		ReadTimeoutMs:  s.readTimeout.Milliseconds(),
+		WriteTimeoutMs: s.writeTimeout.Milliseconds(),
+	})
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"log_monitor/monitor/path_guard"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestStatusRoutes(t *testing.T) {
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+	server := CreateLogServer(dir, path_guard.ContainedSymlinks, defaultLimits, "localhost:0", 100, 2000)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		server.Handler.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+		return recorder
+	}
+
+	response := get("/healthz")
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "ok\n", response.Body.String())
+
+	response = get("/readyz")
+	assert.Equal(t, http.StatusOK, response.Code)
+
+	response = get("/version")
+	assert.Equal(t, http.StatusOK, response.Code)
+	var info versionInfo
+	assert.Nil(t, json.Unmarshal(response.Body.Bytes(), &info))
+	assert.Equal(t, versionInfo{Version: "dev", Commit: "unknown", GoVersion: runtime.Version(), Dir: dir, ReadTimeoutMs: 100, WriteTimeoutMs: 2000}, info)
+
+	// files are still served next to them
+	assert.Equal(t, http.StatusOK, get("/syslog?lines=1").Code)
+}
+
+func TestReadyz(t *testing.T) {
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+	status := &serverStatus{}
+	assert.Nil(t, status.notReady(dir))
+
+	// the volume went away
+	assert.Equal(t, 1, len(status.notReady(filepath.Join(dir, "unmounted"))))
+
+	status.shutdown()
+	assert.Equal(t, []string{"shutting down"}, status.notReady(dir))
+
+	recorder := httptest.NewRecorder()
+	status.serveReady(recorder, httptest.NewRequest("GET", "/readyz", nil), dir)
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Equal(t, "shutting down\n", recorder.Body.String())
+}
+
+func TestReadyz_Roots(t *testing.T) {
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+	unmounted := filepath.Join(dir, "unmounted")
+	handler := &reloadableHandler{status: &serverStatus{}}
+	handler.load(path_guard.NewResolver(dir, path_guard.ContainedSymlinks), defaultLimits,
+		namedRoot{name: "system", resolver: path_guard.NewResolver(dir, path_guard.ContainedSymlinks)},
+		namedRoot{name: "app", resolver: path_guard.NewResolver(unmounted, path_guard.ContainedSymlinks)})
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "root app: ")
+	assert.NotContains(t, recorder.Body.String(), "root system")
+
+	// the root is back
+	assert.Nil(t, os.Mkdir(unmounted, 0700))
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
+
+// the mountpoint an unmounted volume leaves behind can still be read
+func TestReadyz_Unmounted(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("devices are only told apart on linux")
+	}
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+	status := &serverStatus{}
+	status.rememberDevice(dir)
+	assert.Nil(t, status.notReady(dir))
+
+	// as if it was mounted on another one when loaded, which a reload doesn't change
+	status.devices[dir]++
+	status.rememberDevice(dir)
+	problems := status.notReady(dir)
+	if assert.Equal(t, 1, len(problems)) {
+		assert.Contains(t, problems[0], "served directory: on another device")
+	}
+}
+
+func TestReadyz_Unreadable(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root reads anything")
+	}
+	dir, cleanup := createLogTree(t)
+	defer cleanup()
+	assert.Nil(t, os.Chmod(dir, 0))
+	defer os.Chmod(dir, 0700)
+	assert.NotNil(t, checkReadable(dir))
+}
+
+func TestCheckHeadroom(t *testing.T) {
+	assert.Nil(t, checkHeadroom(10, 1024))
+	assert.Nil(t, checkHeadroom(900, 1024))
+	assert.Equal(t, "file descriptors: 1000 of 1024 open", checkHeadroom(1000, 1024).Error())
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"sync"
+)
+
+// holds the current certificate (and client ca pool if mutual tls);
+// a reload only affects new handshakes, established connections keep going
+type tlsReloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	mutex     sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+func newTLSReloader(certFile string, keyFile string, clientCAFile string) (*tlsReloader, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("tls requires both a certificate and a key")
+	}
+	reloader := &tlsReloader{
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: clientCAFile,
+	}
+	if err := reloader.Reload(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// on error, the previously loaded certificates stay in use
+func (t *tlsReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(t.certFile, t.keyFile)
+	if err != nil {
+		return err
+	}
+
+	var pool *x509.CertPool
+	if t.clientCAFile != "" {
+		contents, err := ioutil.ReadFile(t.clientCAFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(contents) {
+			return errors.New("no client ca certificates found in " + t.clientCAFile)
+		}
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.cert = &cert
+	t.clientCAs = pool
+	return nil
+}
+
+func (t *tlsReloader) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		GetConfigForClient: t.getConfigForClient,
+	}
+}
+
+func (t *tlsReloader) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{*t.cert},
+		NextProtos:   []string{"http/1.1"},
+	}
+	if t.clientCAs != nil {
+		config.ClientCAs = t.clientCAs
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func createTestCert(t *testing.T, commonName string, serial int64, parent *testCert) testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return testCert{cert: cert, key: key, der: der}
+}
+
+func (c testCert) writeFiles(t *testing.T, certFile string, keyFile string) {
+	keyDer, err := x509.MarshalECPrivateKey(c.key)
+	assert.Nil(t, err)
+	assert.Nil(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0600))
+	assert.Nil(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+}
+
+func (c testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+// serves the principal of each request back
+func startTLSServer(t *testing.T, reloader *tlsReloader) (string, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	server := http.Server{Handler: withPrincipal(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(getPrincipal(r)))
+	}))}
+	go server.Serve(tls.NewListener(listener, reloader.Config()))
+	return "https://" + listener.Addr().String(), func() { server.Close() }
+}
+
+func tlsClient(ca *testCert, client *testCert) *http.Client {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	config := &tls.Config{RootCAs: pool}
+	if client != nil {
+		config.Certificates = []tls.Certificate{client.tlsCertificate()}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+}
+
+func TestTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	caFile := filepath.Join(dir, "ca.pem")
+
+	ca := createTestCert(t, "test ca", 1, nil)
+	ca.writeFiles(t, caFile, filepath.Join(dir, "ca_key.pem"))
+	server := createTestCert(t, "server", 2, &ca)
+	server.writeFiles(t, certFile, keyFile)
+	client := createTestCert(t, "agent", 3, &ca)
+
+	t.Run("missing key", func(t *testing.T) {
+		_, err := newTLSReloader(certFile, "", "")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("no client certificates", func(t *testing.T) {
+		reloader, err := newTLSReloader(certFile, keyFile, "")
+		assert.Nil(t, err)
+		url, stop := startTLSServer(t, reloader)
+		defer stop()
+
+		res, err := tlsClient(&ca, nil).Get(url)
+		assert.Nil(t, err)
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		assert.Equal(t, anonymousPrincipal, string(body))
+	})
+
+	t.Run("mutual tls", func(t *testing.T) {
+		reloader, err := newTLSReloader(certFile, keyFile, caFile)
+		assert.Nil(t, err)
+		url, stop := startTLSServer(t, reloader)
+		defer stop()
+
+		_, err = tlsClient(&ca, nil).Get(url)
+		assert.NotNil(t, err)
+
+		res, err := tlsClient(&ca, &client).Get(url)
+		assert.Nil(t, err)
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		assert.Equal(t, "agent", string(body))
+	})
+
+	t.Run("reload", func(t *testing.T) {
+		reloader, err := newTLSReloader(certFile, keyFile, "")
+		assert.Nil(t, err)
+		url, stop := startTLSServer(t, reloader)
+		defer stop()
+
+		serial := func() int64 {
+			res, err := tlsClient(&ca, nil).Get(url)
+			assert.Nil(t, err)
+			defer res.Body.Close()
+			return res.TLS.PeerCertificates[0].SerialNumber.Int64()
+		}
+		assert.Equal(t, int64(2), serial())
+
+		renewed := createTestCert(t, "server", 4, &ca)
+		renewed.writeFiles(t, certFile, keyFile)
+		assert.Equal(t, int64(2), serial())
+		assert.Nil(t, reloader.Reload())
+		assert.Equal(t, int64(4), serial())
+
+		// a bad reload keeps the current certificate
+		assert.Nil(t, ioutil.WriteFile(keyFile, []byte("garbage"), 0600))
+		assert.NotNil(t, reloader.Reload())
+		assert.Equal(t, int64(4), serial())
+		server.writeFiles(t, certFile, keyFile)
+	})
+}
@@ -0,0 +1,70 @@
+package path_guard
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// openat walk from the root, never following a symlink
+func openBeneath(root string, components []string) (*os.File, error) {
+	return openBeneathKind(root, components, syscall.S_IFREG)
+}
+
+func openDirBeneath(root string, components []string) (*os.File, error) {
+	return openBeneathKind(root, components, syscall.S_IFDIR)
+}
+
+func openBeneathKind(root string, components []string, kind uint32) (*os.File, error) {
+	fd, err := syscall.Open(root, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: root, Err: err}
+	}
+
+	path := root
+	for i, component := range components {
+		path = filepath.Join(path, component)
+		flags := syscall.O_RDONLY | syscall.O_NOFOLLOW | syscall.O_CLOEXEC
+		if i < len(components)-1 {
+			flags |= syscall.O_DIRECTORY
+		} else {
+			// non blocking so a fifo can't hang the open
+			flags |= syscall.O_NONBLOCK
+		}
+		next, err := syscall.Openat(fd, component, flags, 0)
+		syscall.Close(fd)
+		if err != nil {
+			return nil, openError(path, err)
+		}
+		fd = next
+	}
+
+	var stat syscall.Stat_t
+	if err := syscall.Fstat(fd, &stat); err != nil {
+		syscall.Close(fd)
+		return nil, &os.PathError{Op: "stat", Path: path, Err: err}
+	}
+	if stat.Mode&syscall.S_IFMT != kind {
+		syscall.Close(fd)
+		if kind == syscall.S_IFDIR && stat.Mode&syscall.S_IFMT == syscall.S_IFREG {
+			return nil, &os.PathError{Op: "open", Path: path, Err: syscall.ENOTDIR}
+		}
+		return nil, ErrForbidden
+	}
+	if err := syscall.SetNonblock(fd, false); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), path), nil
+}
+
+func openError(path string, err error) error {
+	// O_NOFOLLOW on a symlink (or O_DIRECTORY on a symlink to a directory)
+	if errors.Is(err, syscall.ELOOP) || errors.Is(err, syscall.ENOTDIR) {
+		if info, statErr := os.Lstat(path); statErr == nil && info.Mode()&os.ModeSymlink != 0 {
+			return ErrForbidden
+		}
+	}
+	return &os.PathError{Op: "open", Path: path, Err: err}
+}
@@ -0,0 +1,56 @@
+//go:build !linux
+// +build !linux
+
+package path_guard
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+func openBeneath(root string, components []string) (*os.File, error) {
+	return openBeneathKind(root, components, false)
+}
+
+func openDirBeneath(root string, components []string) (*os.File, error) {
+	return openBeneathKind(root, components, true)
+}
+
+// without openat, check every component with lstat and make sure
+// what got opened is what was checked
+func openBeneathKind(root string, components []string, directory bool) (*os.File, error) {
+	path := root
+	checked, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	for _, component := range components {
+		path = filepath.Join(path, component)
+		info, err := os.Lstat(path)
+		if err != nil {
+			return nil, err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return nil, ErrForbidden
+		}
+		checked = info
+	}
+	if directory && checked.Mode().IsRegular() {
+		return nil, &os.PathError{Op: "open", Path: path, Err: syscall.ENOTDIR}
+	}
+	if directory != checked.IsDir() || (!directory && !checked.Mode().IsRegular()) {
+		return nil, ErrForbidden
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	opened, err := file.Stat()
+	if err != nil || !os.SameFile(checked, opened) {
+		file.Close()
+		return nil, ErrForbidden
+	}
+	return file, nil
+}
@@ -0,0 +1,212 @@
+package path_guard
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var ErrForbidden = errors.New("path escapes the served directory")
+
+type SymlinkPolicy int
+
+const (
+	RefuseSymlinks    SymlinkPolicy = iota // any symlink along the path is a violation
+	ContainedSymlinks                      // symlinks are followed as long as the target stays under the root
+)
+
+func ParseSymlinkPolicy(policy string) (SymlinkPolicy, error) {
+	switch policy {
+	case "refuse":
+		return RefuseSymlinks, nil
+	case "contained":
+		return ContainedSymlinks, nil
+	}
+	return RefuseSymlinks, errors.New("unknown symlink policy: " + policy)
+}
+
+// confines file access to a single directory tree
+type Resolver struct {
+	root   string
+	policy SymlinkPolicy
+}
+
+func NewResolver(root string, policy SymlinkPolicy) Resolver {
+	return Resolver{root: root, policy: policy}
+}
+
+func (r Resolver) Root() string {
+	return r.root
+}
+
+// opens a regular file given its (still percent encoded) path relative to the root;
+// every component is opened without following symlinks, so a path swapped out from under
+// the check cannot be used to escape
+func (r Resolver) Open(escaped string) (*os.File, error) {
+	resolved, err := r.Resolve(escaped)
+	if err != nil {
+		return nil, err
+	}
+	return r.OpenResolved(resolved)
+}
+
+// the slash separated path relative to the root of what escaped (given as Open takes it) names, once
+// the symlinks along it are followed; with RefuseSymlinks, the path as given. This is the file Open
+// reads, so it's what access to it is decided on
+func (r Resolver) Resolve(escaped string) (string, error) {
+	components, err := splitEscaped(escaped)
+	if err != nil {
+		return "", err
+	}
+	return r.resolveComponents(components)
+}
+
+// like Resolve, for a path as List gives it
+func (r Resolver) ResolveRelative(relative string) (string, error) {
+	components := strings.Split(relative, "/")
+	for i, component := range components {
+		components[i] = url.PathEscape(component)
+	}
+	return r.Resolve(strings.Join(components, "/"))
+}
+
+func (r Resolver) resolveComponents(components []string) (string, error) {
+	if r.policy == ContainedSymlinks {
+		root, err := filepath.EvalSymlinks(r.root)
+		if err != nil {
+			return "", err
+		}
+		if components, err = containedComponents(root, components); err != nil {
+			return "", err
+		}
+	}
+	return strings.Join(components, "/"), nil
+}
+
+// opens a regular file given its path from Resolve. No symlink along it is followed, so the file
+// opened is the one resolved or nothing is
+func (r Resolver) OpenResolved(resolved string) (*os.File, error) {
+	root, err := filepath.EvalSymlinks(r.root)
+	if err != nil {
+		return nil, err
+	}
+	return openBeneath(root, strings.Split(resolved, "/"))
+}
+
+// the slash separated path relative to the root, as Open takes it, before any symlinks are followed
+func Relative(escaped string) (string, error) {
+	components, err := splitEscaped(escaped)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(components, "/"), nil
+}
+
+// lists what is under a directory (given the same way as Open), recursing at most depth levels;
+// entries are slash separated paths relative to the root and directories end in '/'.
+// symlinked directories are never recursed into, so there are no loops to worry about
+func (r Resolver) List(escaped string, depth int) ([]string, error) {
+	var components []string
+	var err error
+	if trimmed := strings.TrimSuffix(escaped, "/"); trimmed != "" {
+		components, err = splitEscaped(trimmed)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	root, err := filepath.EvalSymlinks(r.root)
+	if err != nil {
+		return nil, err
+	}
+	if r.policy == ContainedSymlinks && len(components) > 0 {
+		components, err = containedComponents(root, components)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	entries := make([]string, 0)
+	return entries, r.list(root, components, depth, &entries)
+}
+
+func (r Resolver) list(root string, components []string, depth int, entries *[]string) error {
+	dir, err := openDirBeneath(root, components)
+	if err != nil {
+		return err
+	}
+	infos, err := dir.Readdir(-1)
+	dir.Close()
+	if err != nil {
+		return err
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+
+	for _, info := range infos {
+		child := append(append([]string{}, components...), info.Name())
+		name := strings.Join(child, "/")
+		switch {
+		case info.Mode().IsRegular():
+			*entries = append(*entries, name)
+		case info.IsDir():
+			*entries = append(*entries, name+"/")
+			if depth > 1 {
+				// an unreadable subdirectory shouldn't hide the rest of the tree
+				if err := r.list(root, child, depth-1, entries); err != nil && !os.IsPermission(err) {
+					return err
+				}
+			}
+		case info.Mode()&os.ModeSymlink != 0 && r.policy == ContainedSymlinks:
+			target, err := containedComponents(root, child)
+			if err != nil {
+				continue
+			}
+			if targetInfo, err := os.Stat(filepath.Join(append([]string{root}, target...)...)); err == nil && targetInfo.Mode().IsRegular() {
+				*entries = append(*entries, name)
+			}
+		}
+	}
+	return nil
+}
+
+// rejects anything that could be used to step outside of the root before touching the disk
+func splitEscaped(escaped string) ([]string, error) {
+	lowered := strings.ToLower(escaped)
+	if strings.Contains(lowered, "%2f") || strings.Contains(lowered, "%5c") {
+		return nil, ErrForbidden
+	}
+
+	path, err := url.PathUnescape(escaped)
+	if err != nil {
+		return nil, ErrForbidden
+	}
+	if path == "" || strings.HasPrefix(path, "/") || strings.ContainsAny(path, "\\\x00") {
+		return nil, ErrForbidden
+	}
+
+	components := strings.Split(path, "/")
+	for _, component := range components {
+		if component == "" || component == "." || component == ".." {
+			return nil, ErrForbidden
+		}
+	}
+	return components, nil
+}
+
+// resolves symlinks along the path and gives back the components of the target,
+// as long as the target is still under root
+func containedComponents(root string, components []string) ([]string, error) {
+	target, err := filepath.EvalSymlinks(filepath.Join(append([]string{root}, components...)...))
+	if err != nil {
+		return nil, err
+	}
+
+	relative, err := filepath.Rel(root, target)
+	if err != nil || relative == "." || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		return nil, ErrForbidden
+	}
+	return strings.Split(relative, string(filepath.Separator)), nil
+}
@@ -0,0 +1,275 @@
+package path_guard
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// root/
+//
+//	a.log
+//	sub/b.log
+//	inside_link -> a.log
+//	sub_link -> sub
+//	escape_link -> ../outside/secret
+//	escape_dir_link -> ../outside
+//	fifo
+//
+// outside/secret
+func createTree(t *testing.T) (string, func()) {
+	base, err := ioutil.TempDir("", "path_guard")
+	assert.Nil(t, err)
+
+	root := filepath.Join(base, "root")
+	assert.Nil(t, os.MkdirAll(filepath.Join(root, "sub"), 0700))
+	assert.Nil(t, os.MkdirAll(filepath.Join(base, "outside"), 0700))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(root, "a.log"), []byte("a\n"), 0600))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(root, "sub", "b.log"), []byte("b\n"), 0600))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(base, "outside", "secret"), []byte("secret\n"), 0600))
+	assert.Nil(t, os.Symlink("a.log", filepath.Join(root, "inside_link")))
+	assert.Nil(t, os.Symlink("sub", filepath.Join(root, "sub_link")))
+	assert.Nil(t, os.Symlink("../outside/secret", filepath.Join(root, "escape_link")))
+	assert.Nil(t, os.Symlink("../outside", filepath.Join(root, "escape_dir_link")))
+	assert.Nil(t, syscall.Mkfifo(filepath.Join(root, "fifo"), 0600))
+	return root, func() { os.RemoveAll(base) }
+}
+
+func readAll(t *testing.T, file *os.File) string {
+	defer file.Close()
+	contents, err := ioutil.ReadAll(file)
+	assert.Nil(t, err)
+	return string(contents)
+}
+
+func TestParseSymlinkPolicy(t *testing.T) {
+	policy, err := ParseSymlinkPolicy("refuse")
+	assert.Nil(t, err)
+	assert.Equal(t, RefuseSymlinks, policy)
+
+	policy, err = ParseSymlinkPolicy("contained")
+	assert.Nil(t, err)
+	assert.Equal(t, ContainedSymlinks, policy)
+
+	_, err = ParseSymlinkPolicy("follow")
+	assert.NotNil(t, err)
+}
+
+func TestOpen_Traversal(t *testing.T) {
+	root, cleanup := createTree(t)
+	defer cleanup()
+
+	for _, policy := range []SymlinkPolicy{RefuseSymlinks, ContainedSymlinks} {
+		resolver := NewResolver(root, policy)
+		for _, path := range []string{
+			"",
+			"..",
+			".",
+			"../outside/secret",
+			"sub/../../outside/secret",
+			"sub/./b.log",
+			"sub//b.log",
+			"/etc/passwd",
+			"%2e%2e/outside/secret",
+			"..%2Foutside%2Fsecret",
+			"..%2foutside%2fsecret",
+			"sub%2Fb.log",
+			"..%5Coutside%5Csecret",
+			"..\\outside\\secret",
+			"a.log%00",
+			"%zz",
+			"sub",
+			"fifo",
+		} {
+			_, err := resolver.Open(path)
+			assert.Equal(t, ErrForbidden, err, path)
+		}
+	}
+}
+
+func TestRelative(t *testing.T) {
+	relative, err := Relative("sub/%62.log")
+	assert.Nil(t, err)
+	assert.Equal(t, "sub/b.log", relative)
+
+	_, err = Relative("sub/../a.log")
+	assert.Equal(t, ErrForbidden, err)
+}
+
+func TestOpen_Regular(t *testing.T) {
+	root, cleanup := createTree(t)
+	defer cleanup()
+
+	for _, policy := range []SymlinkPolicy{RefuseSymlinks, ContainedSymlinks} {
+		resolver := NewResolver(root, policy)
+
+		file, err := resolver.Open("a.log")
+		assert.Nil(t, err)
+		assert.Equal(t, "a\n", readAll(t, file))
+
+		file, err = resolver.Open("sub/b.log")
+		assert.Nil(t, err)
+		assert.Equal(t, "b\n", readAll(t, file))
+
+		file, err = resolver.Open("%61.log")
+		assert.Nil(t, err)
+		assert.Equal(t, "a\n", readAll(t, file))
+
+		_, err = resolver.Open("missing.log")
+		assert.True(t, os.IsNotExist(err))
+
+		_, err = resolver.Open("a.log/b.log")
+		assert.NotNil(t, err)
+		assert.NotEqual(t, ErrForbidden, err)
+	}
+}
+
+func TestOpen_RefuseSymlinks(t *testing.T) {
+	root, cleanup := createTree(t)
+	defer cleanup()
+
+	resolver := NewResolver(root, RefuseSymlinks)
+	for _, path := range []string{"inside_link", "sub_link/b.log", "escape_link", "escape_dir_link/secret"} {
+		_, err := resolver.Open(path)
+		assert.Equal(t, ErrForbidden, err, path)
+	}
+}
+
+func TestOpen_ContainedSymlinks(t *testing.T) {
+	root, cleanup := createTree(t)
+	defer cleanup()
+
+	resolver := NewResolver(root, ContainedSymlinks)
+
+	file, err := resolver.Open("inside_link")
+	assert.Nil(t, err)
+	assert.Equal(t, "a\n", readAll(t, file))
+
+	file, err = resolver.Open("sub_link/b.log")
+	assert.Nil(t, err)
+	assert.Equal(t, "b\n", readAll(t, file))
+
+	for _, path := range []string{"escape_link", "escape_dir_link/secret"} {
+		_, err := resolver.Open(path)
+		assert.Equal(t, ErrForbidden, err, path)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	root, cleanup := createTree(t)
+	defer cleanup()
+
+	contained := NewResolver(root, ContainedSymlinks)
+	for escaped, expected := range map[string]string{"a.log": "a.log", "inside_link": "a.log", "sub_link/b.log": "sub/b.log", "sub/b.log": "sub/b.log"} {
+		resolved, err := contained.Resolve(escaped)
+		assert.Nil(t, err, escaped)
+		assert.Equal(t, expected, resolved, escaped)
+	}
+	resolved, err := contained.ResolveRelative("sub_link/b.log")
+	assert.Nil(t, err)
+	assert.Equal(t, "sub/b.log", resolved)
+	_, err = contained.Resolve("escape_link")
+	assert.Equal(t, ErrForbidden, err)
+
+	// what's opened is what was resolved, never a symlink
+	file, err := contained.OpenResolved("sub/b.log")
+	assert.Nil(t, err)
+	assert.Equal(t, "b\n", readAll(t, file))
+	_, err = contained.OpenResolved("inside_link")
+	assert.Equal(t, ErrForbidden, err)
+
+	resolved, err = NewResolver(root, RefuseSymlinks).Resolve("inside_link")
+	assert.Nil(t, err)
+	assert.Equal(t, "inside_link", resolved)
+}
+
+// the served directory itself may be a symlink (/var/log -> /mnt/logs)
+func TestOpen_RootIsSymlink(t *testing.T) {
+	root, cleanup := createTree(t)
+	defer cleanup()
+
+	link := root + "_link"
+	assert.Nil(t, os.Symlink(root, link))
+	defer os.Remove(link)
+
+	file, err := NewResolver(link, RefuseSymlinks).Open("a.log")
+	assert.Nil(t, err)
+	assert.Equal(t, "a\n", readAll(t, file))
+}
+
+// a component swapped for a symlink after any checks still can't be opened through
+func TestOpenBeneath_NoFollow(t *testing.T) {
+	root, cleanup := createTree(t)
+	defer cleanup()
+
+	_, err := openBeneath(root, []string{"escape_link"})
+	assert.Equal(t, ErrForbidden, err)
+
+	_, err = openBeneath(root, []string{"escape_dir_link", "secret"})
+	assert.Equal(t, ErrForbidden, err)
+}
+
+func TestList(t *testing.T) {
+	root, cleanup := createTree(t)
+	defer cleanup()
+	assert.Nil(t, os.MkdirAll(filepath.Join(root, "sub", "deeper", "deepest"), 0700))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(root, "sub", "deeper", "c.log"), []byte("c\n"), 0600))
+
+	t.Run("depth", func(t *testing.T) {
+		resolver := NewResolver(root, RefuseSymlinks)
+
+		entries, err := resolver.List("", 1)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"a.log", "sub/"}, entries)
+
+		entries, err = resolver.List("", 2)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"a.log", "sub/", "sub/b.log", "sub/deeper/"}, entries)
+
+		entries, err = resolver.List("", 10)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"a.log", "sub/", "sub/b.log", "sub/deeper/", "sub/deeper/c.log", "sub/deeper/deepest/"}, entries)
+	})
+
+	t.Run("subdirectory", func(t *testing.T) {
+		resolver := NewResolver(root, RefuseSymlinks)
+
+		entries, err := resolver.List("sub", 1)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"sub/b.log", "sub/deeper/"}, entries)
+
+		entries, err = resolver.List("sub/", 1)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"sub/b.log", "sub/deeper/"}, entries)
+	})
+
+	t.Run("contained symlinks are listed, escaping ones are not", func(t *testing.T) {
+		entries, err := NewResolver(root, ContainedSymlinks).List("", 1)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"a.log", "inside_link", "sub/"}, entries)
+
+		entries, err = NewResolver(root, ContainedSymlinks).List("sub_link", 1)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"sub/b.log", "sub/deeper/"}, entries)
+
+		_, err = NewResolver(root, RefuseSymlinks).List("sub_link", 1)
+		assert.Equal(t, ErrForbidden, err)
+	})
+
+	t.Run("escapes", func(t *testing.T) {
+		resolver := NewResolver(root, ContainedSymlinks)
+		for _, path := range []string{"..", "sub/..", "..%2F", "escape_dir_link"} {
+			_, err := resolver.List(path, 1)
+			assert.Equal(t, ErrForbidden, err, path)
+		}
+	})
+
+	t.Run("not a directory", func(t *testing.T) {
+		_, err := NewResolver(root, RefuseSymlinks).List("a.log", 1)
+		assert.NotNil(t, err)
+		assert.NotEqual(t, ErrForbidden, err)
+	})
+}